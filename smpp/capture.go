@@ -0,0 +1,206 @@
+package smpp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+const (
+	CaptureBinary = 0 // each record is 8 bytes unix nano time, 1 byte direction, 4 bytes length and the frame bytes
+	CaptureJsonl  = 1 // each record is a JSON line {"time":"...","direction":"in","pdu":"<hex>"}
+)
+
+var ErrInvalidCapture = errors.New("invalid capture")
+
+// CaptureRecord a PDU read or written by a connection
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"` // TraceIn or TraceOut
+	Raw       []byte    `json:"-"`         // the frame bytes read from or written to the socket
+}
+
+type captureJson struct {
+	CaptureRecord
+	Pdu string `json:"pdu"`
+}
+
+// Pdu parse the PDU of the record
+func (r *CaptureRecord) Pdu() (pdu.PDU, error) {
+	return pdu.Parse(bytes.NewReader(r.Raw))
+}
+
+// CaptureWriter write capture records to w in CaptureBinary or CaptureJsonl format, it is safe
+// for concurrent use
+type CaptureWriter struct {
+	w      io.Writer
+	format int
+	mu     sync.Mutex
+}
+
+func NewCaptureWriter(w io.Writer, format int) *CaptureWriter {
+	return &CaptureWriter{w: w, format: format}
+}
+
+func (w *CaptureWriter) Write(r *CaptureRecord) error {
+	var buf []byte
+	switch w.format {
+	case CaptureJsonl:
+		bs, err := json.Marshal(captureJson{CaptureRecord: *r, Pdu: hex.EncodeToString(r.Raw)})
+		if err != nil {
+			return err
+		}
+		buf = append(bs, '\n')
+	default:
+		buf = make([]byte, 13, 13+len(r.Raw))
+		binary.BigEndian.PutUint64(buf, uint64(r.Time.UnixNano()))
+		if r.Direction == TraceOut {
+			buf[8] = 1
+		}
+		binary.BigEndian.PutUint32(buf[9:], uint32(len(r.Raw)))
+		buf = append(buf, r.Raw...)
+	}
+
+	w.mu.Lock()
+	_, err := w.w.Write(buf)
+	w.mu.Unlock()
+
+	return err
+}
+
+// ReadCapture read all records written by CaptureWriter from r, the records are sorted by time.
+// Outbound records are stamped when the writing starts, so a response written to the capture
+// before its request is sorted after the request
+func ReadCapture(r io.Reader, format int) ([]*CaptureRecord, error) {
+	var (
+		records []*CaptureRecord
+		err     error
+	)
+	if format == CaptureJsonl {
+		records, err = readCaptureJsonl(r)
+	} else {
+		records, err = readCaptureBinary(r)
+	}
+	slices.SortStableFunc(records, func(a, b *CaptureRecord) int {
+		return a.Time.Compare(b.Time)
+	})
+	return records, err
+}
+
+func readCaptureBinary(r io.Reader) ([]*CaptureRecord, error) {
+	var records []*CaptureRecord
+	br := bufio.NewReader(r)
+	for {
+		var head [13]byte // 时间、方向和帧长度
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			if err == io.EOF {
+				return records, nil
+			}
+			return records, ErrInvalidCapture
+		}
+		length := binary.BigEndian.Uint32(head[9:])
+		if length > data.MAX_PDU_LEN {
+			return records, ErrInvalidCapture
+		}
+		raw := make([]byte, length)
+		if _, err := io.ReadFull(br, raw); err != nil {
+			return records, ErrInvalidCapture
+		}
+
+		record := &CaptureRecord{
+			Time:      time.Unix(0, int64(binary.BigEndian.Uint64(head[:]))),
+			Direction: TraceIn,
+			Raw:       raw,
+		}
+		if head[8] == 1 {
+			record.Direction = TraceOut
+		}
+		records = append(records, record)
+	}
+}
+
+func readCaptureJsonl(r io.Reader) ([]*CaptureRecord, error) {
+	var records []*CaptureRecord
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), 2*data.MAX_PDU_LEN+256)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var cj captureJson
+		if err := json.Unmarshal(line, &cj); err != nil {
+			return records, ErrInvalidCapture
+		}
+		raw, err := hex.DecodeString(cj.Pdu)
+		if err != nil {
+			return records, ErrInvalidCapture
+		}
+		record := cj.CaptureRecord
+		record.Raw = raw
+		records = append(records, &record)
+	}
+	if err := scanner.Err(); err != nil {
+		return records, err
+	}
+	return records, nil
+}
+
+// RecordConnection a Connection decorator which captures every frame read and written. The frames
+// of ClientConnection and ServerConnection are captured as they are on the wire, binds and frames
+// which can not be parsed included. Other connections are captured by marshalling the PDUs read
+// and written after binding
+type RecordConnection struct {
+	Connection
+	w    *CaptureWriter
+	wire bool // 链接自身记录原始字节
+}
+
+func NewRecordConnection(conn Connection, w *CaptureWriter) *RecordConnection {
+	c := &RecordConnection{Connection: conn, w: w}
+	if wc, ok := conn.(interface{ setCapture(*CaptureWriter) }); ok {
+		wc.setCapture(w)
+		c.wire = true
+	}
+	return c
+}
+
+func (c *RecordConnection) Read() (pdu.PDU, error) {
+	p, err := c.Connection.Read()
+	if err == nil && !c.wire {
+		c.record(TraceIn, p)
+	}
+	return p, err
+}
+
+func (c *RecordConnection) Write(p pdu.PDU) (int, error) {
+	n, err := c.Connection.Write(p)
+	if err == nil && !c.wire {
+		c.record(TraceOut, p)
+	}
+	return n, err
+}
+
+func (c *RecordConnection) record(direction string, p pdu.PDU) {
+	buf := pdu.NewBuffer(make([]byte, 0, 64))
+	p.Marshal(buf)
+	_ = c.w.Write(&CaptureRecord{Time: time.Now(), Direction: direction, Raw: buf.Bytes()})
+}
+
+// captureHeader 读取帧头中的命令和序列号，帧长度不足时返回 false
+func captureHeader(raw []byte) (data.CommandIDType, int32, bool) {
+	if len(raw) < 16 {
+		return 0, 0, false
+	}
+	return data.CommandIDType(binary.BigEndian.Uint32(raw[4:])), int32(binary.BigEndian.Uint32(raw[12:])), true
+}
@@ -8,7 +8,13 @@ import (
 	"github.com/linxGnu/gosmpp/pdu"
 )
 
+const (
+	RoleClient = 1 // ESME
+	RoleServer = 2 // SMSC
+)
+
 type Connection interface {
+	Role() int
 	SelfAddr() string
 	PeerAddr() string
 	Deadline(time.Time) error
@@ -23,13 +29,17 @@ type Connection interface {
 type ClientConnection struct {
 	conf     ClientConnectionConfig
 	conn     net.Conn
+	accepted net.Conn // the socket accepted with an outbind
+	smscIdx  int      // the index of SMSC address to dial
 	selfAddr string
 	peerAddr string
+	tap      wireTap
 }
 
 type ClientConnectionConfig struct {
 	Dial         Dial
 	Smsc         string
+	Smscs        []string // failover addresses, rotated after Smsc when dialing failed
 	SystemId     string
 	Password     string
 	BindType     pdu.BindingType
@@ -37,13 +47,27 @@ type ClientConnectionConfig struct {
 	AddressRange pdu.AddressRange
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	Trace        TraceSink // record every PDU read and written, nil means disabled
+	TracePlain   bool      // do not mask password and short_message in traces
 }
 
 func NewClientConnection(conf ClientConnectionConfig) *ClientConnection {
 	if conf.Dial == nil {
 		conf.Dial = DefaultDial
 	}
-	return &ClientConnection{conf: conf}
+	return &ClientConnection{conf: conf, tap: wireTap{trace: conf.Trace, plain: conf.TracePlain}}
+}
+
+// NewOutbindClientConnection create a client connection that binds over the socket which
+// an outbind is received from, it dials ClientConnectionConfig.Smsc when it is redialed
+func NewOutbindClientConnection(conn net.Conn, conf ClientConnectionConfig) *ClientConnection {
+	c := NewClientConnection(conf)
+	c.accepted = conn
+	return c
+}
+
+func (c *ClientConnection) Role() int {
+	return RoleClient
 }
 
 func (c *ClientConnection) SelfAddr() string {
@@ -67,15 +91,38 @@ func (c *ClientConnection) BindType() pdu.BindingType {
 }
 
 func (c *ClientConnection) Dial() (err error) {
+	err = c.dial()
+	if err != nil {
+		c.smscIdx++
+	}
+	return err
+}
+
+func (c *ClientConnection) smsc() string {
+	addrs := c.conf.Smscs
+	if c.conf.Smsc != "" {
+		addrs = append([]string{c.conf.Smsc}, addrs...)
+	}
+	if len(addrs) == 0 {
+		return ""
+	}
+	return addrs[c.smscIdx%len(addrs)]
+}
+
+func (c *ClientConnection) dial() (err error) {
 	// 关闭旧链接
 	if c.conn != nil {
 		_ = c.conn.Close()
 	}
 
-	// 连接
-	c.conn, err = c.conf.Dial(c.conf.Smsc)
-	if err != nil {
-		return err
+	// 连接，收到 outbind 的链接直接使用
+	if c.accepted != nil {
+		c.conn, c.accepted = c.accepted, nil
+	} else {
+		c.conn, err = c.conf.Dial(c.smsc())
+		if err != nil {
+			return err
+		}
 	}
 
 	// 获取两端地址
@@ -136,10 +183,16 @@ func (c *ClientConnection) bind() error {
 }
 
 func (c *ClientConnection) Read() (pdu.PDU, error) {
+	if c.tap.enabled() {
+		return tapReadConn(c.conn, c.conf.ReadTimeout, &c.tap)
+	}
 	return ReadConn(c.conn, c.conf.ReadTimeout)
 }
 
 func (c *ClientConnection) Write(pd pdu.PDU) (int, error) {
+	if c.tap.enabled() {
+		return tapWriteConn(c.conn, pd, c.conf.WriteTimeout, &c.tap)
+	}
 	return WriteConn(c.conn, pd, c.conf.WriteTimeout)
 }
 
@@ -147,6 +200,10 @@ func (c *ClientConnection) Close(bye bool) error {
 	return CloseConn(c.conn, bye)
 }
 
+func (c *ClientConnection) setCapture(w *CaptureWriter) {
+	c.tap.capture = w
+}
+
 type ServerConnection struct {
 	conf     ServerConnectionConfig
 	conn     net.Conn
@@ -154,18 +211,40 @@ type ServerConnection struct {
 	bindType pdu.BindingType
 	selfAddr string
 	peerAddr string
+	tap      wireTap
 }
 
 type ServerConnectionConfig struct {
 	Authenticate ServerConnectionAuthenticate
 	ReadTimeout  time.Duration
 	WriteTimeout time.Duration
+	Trace        TraceSink // record every PDU read and written, nil means disabled
+	TracePlain   bool      // do not mask password and short_message in traces
+
+	// the following fields are used by the outbind connection
+	Dial     Dial
+	Esme     string
+	SystemId string
+	Password string
 }
 
 type ServerConnectionAuthenticate func(conn *ServerConnection, systemId string, password string) data.CommandStatusType
 
 func NewServerConnection(conn net.Conn, conf ServerConnectionConfig) *ServerConnection {
-	return &ServerConnection{conn: conn, conf: conf}
+	return &ServerConnection{conn: conn, conf: conf, tap: wireTap{trace: conf.Trace, plain: conf.TracePlain}}
+}
+
+// NewOutbindConnection create a server connection that dials ServerConnectionConfig.Esme and
+// sends an outbind to it, then waits for the ESME to bind over the socket
+func NewOutbindConnection(conf ServerConnectionConfig) *ServerConnection {
+	if conf.Dial == nil {
+		conf.Dial = DefaultDial
+	}
+	return &ServerConnection{conf: conf, tap: wireTap{trace: conf.Trace, plain: conf.TracePlain}}
+}
+
+func (c *ServerConnection) Role() int {
+	return RoleServer
 }
 
 func (c *ServerConnection) SelfAddr() string {
@@ -189,13 +268,44 @@ func (c *ServerConnection) BindType() pdu.BindingType {
 }
 
 func (c *ServerConnection) Dial() error {
+	if c.conf.Esme != "" {
+		if err := c.outbind(); err != nil {
+			return err
+		}
+	}
+
 	err := c.dial()
-	if err != nil {
+	if err != nil && c.conn != nil {
 		_ = c.conn.Close()
 	}
 	return err
 }
 
+func (c *ServerConnection) outbind() (err error) {
+	// 关闭旧链接
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+
+	// 连接
+	c.conn, err = c.conf.Dial(c.conf.Esme)
+	if err != nil {
+		c.conn = nil
+		return err
+	}
+
+	// 发送 outbind 请求，之后等待对端绑定
+	op := pdu.NewOutbind().(*pdu.Outbind)
+	op.SystemID = c.conf.SystemId
+	op.Password = c.conf.Password
+	if _, err = c.Write(op); err != nil {
+		_ = c.conn.Close()
+		return err
+	}
+
+	return nil
+}
+
 func (c *ServerConnection) dial() error {
 	// 关闭旧链接
 	if c.conn == nil {
@@ -247,13 +357,23 @@ func (c *ServerConnection) dial() error {
 }
 
 func (c *ServerConnection) Read() (pdu.PDU, error) {
+	if c.tap.enabled() {
+		return tapReadConn(c.conn, c.conf.ReadTimeout, &c.tap)
+	}
 	return ReadConn(c.conn, c.conf.ReadTimeout)
 }
 
 func (c *ServerConnection) Write(pd pdu.PDU) (int, error) {
+	if c.tap.enabled() {
+		return tapWriteConn(c.conn, pd, c.conf.WriteTimeout, &c.tap)
+	}
 	return WriteConn(c.conn, pd, c.conf.WriteTimeout)
 }
 
 func (c *ServerConnection) Close(bye bool) error {
 	return CloseConn(c.conn, bye)
 }
+
+func (c *ServerConnection) setCapture(w *CaptureWriter) {
+	c.tap.capture = w
+}
@@ -13,8 +13,15 @@ var (
 	ErrWindowFull       = errors.New("window full")
 	ErrNotAllowed       = errors.New("not allowed")
 	ErrConnectionClosed = errors.New("connection closed")
+	ErrConnectionLost   = errors.New("connection lost")
 	ErrResponseTimeout  = errors.New("response timeout")
 	ErrConnectionIsNil  = errors.New("connection is nil")
+	ErrNotResponsive    = errors.New("not responsive")
+	ErrRequestCanceled  = errors.New("request canceled")
+	ErrServerClosed     = errors.New("server closed")
+	ErrSessionDraining  = errors.New("session draining")
+	ErrSequenceInUse    = errors.New("sequence in use")
+	ErrTooManySegments  = errors.New("too many segments")
 )
 
 type StatusError struct {
@@ -28,3 +35,21 @@ func NewStatusError(status data.CommandStatusType) *StatusError {
 func (e *StatusError) Error() string {
 	return fmt.Sprintf("(%d) %s", e.status, e.status.Desc())
 }
+
+func (e *StatusError) Status() data.CommandStatusType {
+	return e.status
+}
+
+// IsPermanentError report whether the error is a permanent bind failure, such as invalid
+// password or system ID, which can not be recovered by redialing
+func IsPermanentError(err error) bool {
+	var se *StatusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.status {
+	case data.ESME_RINVPASWD, data.ESME_RINVSYSID, data.ESME_RINVSYSTYP, data.ESME_RPROVNOTALLWD:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package smpp
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter a token bucket rate limiter. Sessions limited by the same Limiter share the
+// rate, it can be used to respect the throughput limit of an account with several binds
+type Limiter struct {
+	rate   float64    // 每秒产生的令牌数
+	burst  float64    // 令牌桶容量
+	tokens float64    // 当前令牌数
+	last   time.Time  // 上次更新令牌的时间
+	mu     sync.Mutex //
+}
+
+// NewLimiter create a limiter that allows rate PDUs per second with bursts of at most burst
+// PDUs, the rate <= 0 means unlimited
+func NewLimiter(rate float64, burst int) *Limiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Limiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (l *Limiter) advance(curr time.Time) {
+	if elapsed := curr.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.burst {
+			l.tokens = l.burst
+		}
+		l.last = curr
+	}
+}
+
+// Allow take a token if it is available now
+func (l *Limiter) Allow() bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.advance(time.Now())
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+
+	return true
+}
+
+// Wait block until a token is available or ctx is done
+func (l *Limiter) Wait(ctx context.Context) error {
+	if l.rate <= 0 {
+		return nil
+	}
+
+	// 预占令牌，令牌不足时等待令牌补齐
+	l.mu.Lock()
+	l.advance(time.Now())
+	l.tokens--
+	wait := time.Duration(0)
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		l.tokens++
+		l.mu.Unlock()
+		return ctx.Err()
+	}
+}
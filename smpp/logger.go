@@ -0,0 +1,75 @@
+package smpp
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Logger the logger of sessions, servers and listeners. The fields are alternating keys and values
+// like log/slog, such as "session_id", "6ad3c5ac018107c7", "pdu", "SUBMIT_SM"
+type Logger interface {
+	Debug(msg string, fields ...any)
+	Info(msg string, fields ...any)
+	Warn(msg string, fields ...any)
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger create a Logger by log/slog, slog.Default() is used if l is nil
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &slogLogger{l: l}
+}
+
+func (l *slogLogger) Debug(msg string, fields ...any) {
+	l.l.Debug(msg, fields...)
+}
+
+func (l *slogLogger) Info(msg string, fields ...any) {
+	l.l.Info(msg, fields...)
+}
+
+func (l *slogLogger) Warn(msg string, fields ...any) {
+	l.l.Warn(msg, fields...)
+}
+
+type logrusLogger struct {
+	l *logrus.Logger
+}
+
+// NewLogrusLogger create a Logger by logrus, the fields are passed as logrus.Fields
+func NewLogrusLogger(l *logrus.Logger) Logger {
+	return &logrusLogger{l: l}
+}
+
+func (l *logrusLogger) Debug(msg string, fields ...any) {
+	if l.l.IsLevelEnabled(logrus.DebugLevel) {
+		l.l.WithFields(logrusFields(fields)).Debug(msg)
+	}
+}
+
+func (l *logrusLogger) Info(msg string, fields ...any) {
+	if l.l.IsLevelEnabled(logrus.InfoLevel) {
+		l.l.WithFields(logrusFields(fields)).Info(msg)
+	}
+}
+
+func (l *logrusLogger) Warn(msg string, fields ...any) {
+	if l.l.IsLevelEnabled(logrus.WarnLevel) {
+		l.l.WithFields(logrusFields(fields)).Warn(msg)
+	}
+}
+
+func logrusFields(fields []any) logrus.Fields {
+	lf := make(logrus.Fields, len(fields)/2)
+	for i := 0; i+1 < len(fields); i += 2 {
+		lf[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+	return lf
+}
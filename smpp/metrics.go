@@ -0,0 +1,283 @@
+package smpp
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// Metrics receive the events of sessions, set it as SessionConfig.Metrics. Collector is the
+// built-in implementation, implement Metrics to bridge other metrics systems
+type Metrics interface {
+	PduSent(sess *Session, p pdu.PDU)                               // a PDU is written to peer terminal
+	PduReceived(sess *Session, p pdu.PDU)                           // a PDU is read from peer terminal
+	Responded(sess *Session, response *Response, rtt time.Duration) // a response PDU is taken from window
+	TimedOut(sess *Session, request *Request)                       // a request is taken from window by timeout
+	Bound(sess *Session)                                            // the connection is bound
+	Redialed(sess *Session, attempt int, err error)                 // an attempt of redialing is done
+	ConnClosed(sess *Session, reason string)                        // the connection is closed
+	SessionClosed(sess *Session)                                    // the session is closed completely
+}
+
+// MetricsBuckets the default upper bounds in seconds of response latency histogram buckets
+var MetricsBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Collector collect metrics of sessions in memory and expose them in Prometheus text format, it
+// implements Metrics and http.Handler. Series of a session are removed when the session is closed
+// completely, except smpp_closes_total which is labelled by system_id only
+type Collector struct {
+	buckets  []float64
+	sessions map[string]*sessionMetrics
+	closes   map[[2]string]uint64 // system_id, reason
+	mu       sync.Mutex
+}
+
+type sessionMetrics struct {
+	sess     *Session
+	sent     map[[2]string]uint64         // command, status
+	received map[[2]string]uint64         // command, status
+	latency  map[string]*metricsHistogram // command
+	timeouts uint64
+	binds    uint64
+	redials  map[string]uint64 // result
+}
+
+type metricsHistogram struct {
+	counts []uint64 // 每个桶的累计数量
+	count  uint64
+	sum    float64
+}
+
+// NewCollector create a Collector, buckets are the upper bounds in seconds of response latency
+// histogram buckets, MetricsBuckets is used if buckets is empty
+func NewCollector(buckets ...float64) *Collector {
+	if len(buckets) == 0 {
+		buckets = MetricsBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+
+	return &Collector{
+		buckets:  buckets,
+		sessions: make(map[string]*sessionMetrics),
+		closes:   make(map[[2]string]uint64),
+	}
+}
+
+// session 获取会话的指标，调用者需要持有锁
+func (c *Collector) session(sess *Session) *sessionMetrics {
+	m, ok := c.sessions[sess.Id()]
+	if !ok {
+		m = &sessionMetrics{
+			sess:     sess,
+			sent:     make(map[[2]string]uint64),
+			received: make(map[[2]string]uint64),
+			latency:  make(map[string]*metricsHistogram),
+			redials:  make(map[string]uint64),
+		}
+		c.sessions[sess.Id()] = m
+	}
+	return m
+}
+
+func (c *Collector) PduSent(sess *Session, p pdu.PDU) {
+	header := p.GetHeader()
+	c.mu.Lock()
+	c.session(sess).sent[[2]string{header.CommandID.String(), header.CommandStatus.String()}]++
+	c.mu.Unlock()
+}
+
+func (c *Collector) PduReceived(sess *Session, p pdu.PDU) {
+	header := p.GetHeader()
+	c.mu.Lock()
+	c.session(sess).received[[2]string{header.CommandID.String(), header.CommandStatus.String()}]++
+	c.mu.Unlock()
+}
+
+func (c *Collector) Responded(sess *Session, response *Response, rtt time.Duration) {
+	command := response.Request.Pdu.GetHeader().CommandID.String()
+	seconds := rtt.Seconds()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m := c.session(sess)
+	h, ok := m.latency[command]
+	if !ok {
+		h = &metricsHistogram{counts: make([]uint64, len(c.buckets))}
+		m.latency[command] = h
+	}
+	for i, bound := range c.buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (c *Collector) TimedOut(sess *Session, _ *Request) {
+	c.mu.Lock()
+	c.session(sess).timeouts++
+	c.mu.Unlock()
+}
+
+func (c *Collector) Bound(sess *Session) {
+	c.mu.Lock()
+	c.session(sess).binds++
+	c.mu.Unlock()
+}
+
+func (c *Collector) Redialed(sess *Session, _ int, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	c.mu.Lock()
+	c.session(sess).redials[result]++
+	c.mu.Unlock()
+}
+
+func (c *Collector) ConnClosed(sess *Session, reason string) {
+	c.mu.Lock()
+	c.closes[[2]string{sess.SystemId(), reason}]++
+	c.mu.Unlock()
+}
+
+func (c *Collector) SessionClosed(sess *Session) {
+	c.mu.Lock()
+	delete(c.sessions, sess.Id())
+	c.mu.Unlock()
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_ = c.Write(w)
+}
+
+// Write write all metrics to w in Prometheus text format
+func (c *Collector) Write(w io.Writer) error {
+	var b strings.Builder
+
+	c.mu.Lock()
+	ids := make([]string, 0, len(c.sessions))
+	for id := range c.sessions {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	// 按会话输出计数
+	pdus := func(name, help string, get func(*sessionMetrics) map[[2]string]uint64) {
+		metricsHeader(&b, name, help, "counter")
+		for _, id := range ids {
+			m := c.sessions[id]
+			values := get(m)
+			for _, key := range sortedKeys(values) {
+				metricsLine(&b, name, metricsLabels(m.sess, "command", key[0], "status", key[1]), float64(values[key]))
+			}
+		}
+	}
+	pdus("smpp_pdus_sent_total", "PDUs written to peer terminal.", func(m *sessionMetrics) map[[2]string]uint64 { return m.sent })
+	pdus("smpp_pdus_received_total", "PDUs read from peer terminal.", func(m *sessionMetrics) map[[2]string]uint64 { return m.received })
+
+	metricsHeader(&b, "smpp_window_requests", "Requests waiting for response in window.", "gauge")
+	for _, id := range ids {
+		m := c.sessions[id]
+		metricsLine(&b, "smpp_window_requests", metricsLabels(m.sess), float64(m.sess.InFlight()))
+	}
+
+	metricsHeader(&b, "smpp_response_latency_seconds", "Latency from submitting a request to taking its response from window.", "histogram")
+	for _, id := range ids {
+		m := c.sessions[id]
+		commands := make([]string, 0, len(m.latency))
+		for command := range m.latency {
+			commands = append(commands, command)
+		}
+		slices.Sort(commands)
+		for _, command := range commands {
+			h := m.latency[command]
+			for i, bound := range c.buckets {
+				metricsLine(&b, "smpp_response_latency_seconds_bucket", metricsLabels(m.sess, "command", command, "le", fmt.Sprint(bound)), float64(h.counts[i]))
+			}
+			metricsLine(&b, "smpp_response_latency_seconds_bucket", metricsLabels(m.sess, "command", command, "le", "+Inf"), float64(h.count))
+			metricsLine(&b, "smpp_response_latency_seconds_sum", metricsLabels(m.sess, "command", command), h.sum)
+			metricsLine(&b, "smpp_response_latency_seconds_count", metricsLabels(m.sess, "command", command), float64(h.count))
+		}
+	}
+
+	metricsHeader(&b, "smpp_response_timeouts_total", "Requests taken from window by timeout.", "counter")
+	for _, id := range ids {
+		m := c.sessions[id]
+		metricsLine(&b, "smpp_response_timeouts_total", metricsLabels(m.sess), float64(m.timeouts))
+	}
+
+	metricsHeader(&b, "smpp_binds_total", "Successful binds.", "counter")
+	for _, id := range ids {
+		m := c.sessions[id]
+		metricsLine(&b, "smpp_binds_total", metricsLabels(m.sess), float64(m.binds))
+	}
+
+	metricsHeader(&b, "smpp_redials_total", "Attempts of redialing by result.", "counter")
+	for _, id := range ids {
+		m := c.sessions[id]
+		for _, result := range []string{"success", "failure"} {
+			if n, ok := m.redials[result]; ok {
+				metricsLine(&b, "smpp_redials_total", metricsLabels(m.sess, "result", result), float64(n))
+			}
+		}
+	}
+
+	metricsHeader(&b, "smpp_closes_total", "Closed connections by reason.", "counter")
+	for _, key := range sortedKeys(c.closes) {
+		metricsLine(&b, "smpp_closes_total", metricsLabelPairs("system_id", key[0], "reason", key[1]), float64(c.closes[key]))
+	}
+	c.mu.Unlock()
+
+	_, err := io.WriteString(w, b.String())
+
+	return err
+}
+
+func sortedKeys(m map[[2]string]uint64) [][2]string {
+	keys := make([][2]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b [2]string) int {
+		if n := strings.Compare(a[0], b[0]); n != 0 {
+			return n
+		}
+		return strings.Compare(a[1], b[1])
+	})
+	return keys
+}
+
+func metricsHeader(b *strings.Builder, name string, help string, typ string) {
+	_, _ = fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func metricsLine(b *strings.Builder, name string, labels string, value float64) {
+	_, _ = fmt.Fprintf(b, "%s{%s} %v\n", name, labels, value)
+}
+
+func metricsLabels(sess *Session, pairs ...string) string {
+	return metricsLabelPairs(append([]string{"system_id", sess.SystemId(), "session_id", sess.Id()}, pairs...)...)
+}
+
+func metricsLabelPairs(pairs ...string) string {
+	var b strings.Builder
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		_, _ = fmt.Fprintf(&b, `%s="%s"`, pairs[i], replacer.Replace(pairs[i+1]))
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package smpp
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// OutbindListener accept the outbind from SMSC, then bind as a receiver over the socket and
+// run a session on it
+type OutbindListener struct {
+	conf   OutbindListenerConfig //
+	listen net.Listener          //
+	closed int32                 // 是否已关闭
+	mu     sync.Mutex            //
+}
+
+type OutbindListenerConfig struct {
+	Addr         string                                      // listen address
+	Connection   ClientConnectionConfig                      // config of the receiver connection, the BindType is always Receiver
+	Authenticate func(systemId string, password string) bool // check the system id and password of outbind, nil means accept all
+	SessionNewer func(*ClientConnection) SessionConfig       // create the session config of each connection
+}
+
+func NewOutbindListener(conf OutbindListenerConfig) *OutbindListener {
+	conf.Connection.BindType = pdu.Receiver
+	return &OutbindListener{conf: conf}
+}
+
+// ListenAndServe listen on the TCP address OutbindListenerConfig.Addr and accept outbinds
+func (l *OutbindListener) ListenAndServe() error {
+	listen, err := net.Listen("tcp", l.conf.Addr)
+	if err != nil {
+		return err
+	}
+	return l.Serve(listen)
+}
+
+// Serve accept outbinds on the listener, it always returns a non-nil error, and returns
+// ErrServerClosed after Close
+func (l *OutbindListener) Serve(listen net.Listener) error {
+	l.mu.Lock()
+	if l.isClosed() {
+		l.mu.Unlock()
+		_ = listen.Close()
+		return ErrServerClosed
+	}
+	l.listen = listen
+	l.mu.Unlock()
+
+	for {
+		conn, err := listen.Accept()
+		if err != nil {
+			if l.isClosed() {
+				return ErrServerClosed
+			}
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go l.serve(conn)
+	}
+}
+
+func (l *OutbindListener) serve(conn net.Conn) {
+	// 读取 outbind 请求
+	var (
+		p   pdu.PDU
+		err error
+	)
+	if trace := l.conf.Connection.Trace; trace != nil {
+		p, err = tapReadConn(conn, l.conf.Connection.ReadTimeout, &wireTap{trace: trace, plain: l.conf.Connection.TracePlain})
+	} else {
+		p, err = ReadConn(conn, l.conf.Connection.ReadTimeout)
+	}
+	if err != nil {
+		l.warn("Read outbind failed", "peer_addr", conn.RemoteAddr().String(), "error", err)
+		_ = conn.Close()
+		return
+	}
+	op, ok := p.(*pdu.Outbind)
+	if !ok {
+		l.warn("Received unexpected pdu instead of outbind", "peer_addr", conn.RemoteAddr().String(), "pdu", p.GetHeader().CommandID.String())
+		_ = conn.Close()
+		return
+	}
+	if l.conf.Authenticate != nil && !l.conf.Authenticate(op.SystemID, op.Password) {
+		l.warn("Outbind auth failed", "peer_addr", conn.RemoteAddr().String(), "system_id", op.SystemID)
+		_ = conn.Close()
+		return
+	}
+
+	// 在该链接上以 receiver 绑定，链接断开后不再重连
+	cc := NewOutbindClientConnection(conn, l.conf.Connection)
+	conf := SessionConfig{}
+	if l.conf.SessionNewer != nil {
+		conf = l.conf.SessionNewer(cc)
+	}
+	conf.AttemptDial = 0
+	conf.RedialPolicy = nil
+
+	if _, err = NewSession(cc, conf); err != nil {
+		l.warn("Create session failed", "peer_addr", conn.RemoteAddr().String(), "error", err)
+	}
+}
+
+func (l *OutbindListener) isClosed() bool {
+	return atomic.LoadInt32(&l.closed) == 1
+}
+
+func (l *OutbindListener) warn(m string, fields ...any) {
+	if _log != nil {
+		_log.Warn(m, append([]any{"outbind_listener", l.conf.Addr}, fields...)...)
+	}
+}
+
+// Close stop accepting outbinds, the established sessions are not affected
+func (l *OutbindListener) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !atomic.CompareAndSwapInt32(&l.closed, 0, 1) {
+		return ErrServerClosed
+	}
+	if l.listen != nil {
+		return l.listen.Close()
+	}
+
+	return nil
+}
@@ -0,0 +1,76 @@
+package smpp
+
+import (
+	"context"
+	"sync"
+)
+
+// Pending the handle of a submitted request, it will be resolved when the response PDU is
+// received, the request is timeout, the connection is closed or the request is canceled
+type Pending struct {
+	request  *Request
+	response *Response
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newPending(request *Request) *Pending {
+	return &Pending{
+		request: request,
+		done:    make(chan struct{}),
+	}
+}
+
+func (p *Pending) resolve(response *Response) bool {
+	resolved := false
+	p.once.Do(func() {
+		p.response = response
+		close(p.done)
+		resolved = true
+	})
+	return resolved
+}
+
+func (p *Pending) resolved() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Request get the submitted request
+func (p *Pending) Request() *Request {
+	return p.request
+}
+
+// Done get a channel that is closed when the request is resolved
+func (p *Pending) Done() <-chan struct{} {
+	return p.done
+}
+
+// Wait block until the request is resolved or ctx is done, the returned error is only the
+// error of ctx, the failure of request is indicated by Response.Error
+func (p *Pending) Wait(ctx context.Context) (*Response, error) {
+	select {
+	case <-p.done:
+		return p.response, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Cancel resolve the request with ErrRequestCanceled. The request will not be sent if it is
+// still queued, otherwise it still takes up the window until its response or timeout
+func (p *Pending) Cancel() {
+	p.resolve(NewResponse(p.request, nil, ErrRequestCanceled))
+}
+
+// Response get the response of request, it returns nil if the request is not resolved yet
+func (p *Pending) Response() *Response {
+	if !p.resolved() {
+		return nil
+	}
+	return p.response
+}
@@ -0,0 +1,96 @@
+package smpp
+
+import (
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+var (
+	// 会话级别的 pdu，任意角色和绑定类型都可以收发
+	sessionPdus = map[data.CommandIDType]bool{
+		data.ENQUIRE_LINK:      true,
+		data.ENQUIRE_LINK_RESP: true,
+		data.UNBIND:            true,
+		data.UNBIND_RESP:       true,
+		data.GENERIC_NACK:      true,
+	}
+
+	// ESME 发往 SMSC 的请求，需要以 transmitter 或 transceiver 绑定
+	esmePdus = map[data.CommandIDType]bool{
+		data.SUBMIT_SM:    true,
+		data.SUBMIT_MULTI: true,
+		data.DATA_SM:      true,
+		data.QUERY_SM:     true,
+		data.CANCEL_SM:    true,
+		data.REPLACE_SM:   true,
+	}
+
+	// SMSC 发往 ESME 的请求，需要以 receiver 或 transceiver 绑定
+	smscPdus = map[data.CommandIDType]bool{
+		data.DELIVER_SM:         true,
+		data.DATA_SM:            true,
+		data.ALERT_NOTIFICATION: true,
+	}
+)
+
+// isResponse 命令 ID 的最高位表示响应
+func isResponse(id data.CommandIDType) bool {
+	return uint32(id)&0x80000000 != 0
+}
+
+// AllowPdu report whether the PDU of command id is allowed to be sent (out is true) or
+// received (out is false) by a session with the role and bind type, according to SMPP 3.4
+func AllowPdu(role int, bindType pdu.BindingType, out bool, id data.CommandIDType) bool {
+	if sessionPdus[id] {
+		return true
+	}
+
+	// 响应与对应请求的方向相反
+	if isResponse(id) {
+		id = data.CommandIDType(uint32(id) &^ 0x80000000)
+		out = !out
+	}
+
+	if (role == RoleClient) == out {
+		return bindType != pdu.Receiver && esmePdus[id]
+	}
+
+	return bindType != pdu.Transmitter && smscPdus[id]
+}
+
+// rejectPdu create the response PDU to reject a received request PDU, responses must not be
+// rejected. A responsive PDU is responded with the status, others are responded with generic_nack
+func rejectPdu(p pdu.PDU, status data.CommandStatusType) pdu.PDU {
+	if !p.CanResponse() {
+		rp := pdu.NewGenericNack().(*pdu.GenericNack)
+		rp.CommandStatus = status
+		rp.SetSequenceNumber(p.GetSequenceNumber())
+		return rp
+	}
+
+	rp := p.GetResponse()
+	switch t := rp.(type) {
+	case *pdu.BindResp:
+		t.CommandStatus = status
+	case *pdu.SubmitSMResp:
+		t.CommandStatus = status
+	case *pdu.SubmitMultiResp:
+		t.CommandStatus = status
+	case *pdu.DeliverSMResp:
+		t.CommandStatus = status
+	case *pdu.DataSMResp:
+		t.CommandStatus = status
+	case *pdu.QuerySMResp:
+		t.CommandStatus = status
+	case *pdu.CancelSMResp:
+		t.CommandStatus = status
+	case *pdu.ReplaceSMResp:
+		t.CommandStatus = status
+	case *pdu.EnquireLinkResp:
+		t.CommandStatus = status
+	case *pdu.UnbindResp:
+		t.CommandStatus = status
+	}
+
+	return rp
+}
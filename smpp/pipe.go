@@ -0,0 +1,86 @@
+package smpp
+
+import (
+	"net"
+	"sync"
+)
+
+// PipeListener an in-memory net.Listener, connections are created by PipeListener.Dial with
+// net.Pipe, so servers and clients can be tested without ports. Set PipeListener.Dial as
+// ClientConnectionConfig.Dial and serve the listener by Server.Serve
+type PipeListener struct {
+	conns  chan net.Conn
+	done   chan struct{}
+	closed sync.Once
+}
+
+func NewPipeListener() *PipeListener {
+	return &PipeListener{
+		conns: make(chan net.Conn),
+		done:  make(chan struct{}),
+	}
+}
+
+// Dial create a connection to the listener, addr is ignored
+func (l *PipeListener) Dial(string) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *PipeListener) Close() error {
+	l.closed.Do(func() {
+		close(l.done)
+	})
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string {
+	return "pipe"
+}
+
+func (pipeAddr) String() string {
+	return "pipe"
+}
+
+// NewPipeConnections create a client connection and a server connection connected by net.Pipe,
+// cc.Dial is replaced. Both connections must be dialed concurrently, such as creating the server
+// session in a goroutine, because binding blocks until the peer reads. The client connection can
+// not be redialed
+func NewPipeConnections(cc ClientConnectionConfig, sc ServerConnectionConfig) (*ClientConnection, *ServerConnection) {
+	client, server := net.Pipe()
+
+	// 只有第一次拨号返回管道
+	var once sync.Once
+	cc.Dial = func(string) (net.Conn, error) {
+		conn := net.Conn(nil)
+		once.Do(func() {
+			conn = client
+		})
+		if conn == nil {
+			return nil, ErrConnectionClosed
+		}
+		return conn, nil
+	}
+
+	return NewClientConnection(cc), NewServerConnection(server, sc)
+}
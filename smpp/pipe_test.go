@@ -0,0 +1,133 @@
+package smpp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// pipeServerConfig 返回的会话配置响应 submit_sm，并在响应后下发 deliver
+func pipeServerConfig(deliver pdu.PDU) SessionConfig {
+	return SessionConfig{
+		OnReceive: func(sess *Session, p pdu.PDU) pdu.PDU {
+			rp := p.GetResponse()
+			if sr, ok := rp.(*pdu.SubmitSMResp); ok {
+				sr.MessageID = "MSG1"
+				_ = sess.Write(deliver, nil)
+			}
+			return rp
+		},
+	}
+}
+
+func pipeAuthenticate(_ *ServerConnection, systemId string, password string) data.CommandStatusType {
+	if systemId == "user1" && password == "user1" {
+		return data.ESME_ROK
+	}
+	return data.ESME_RINVPASWD
+}
+
+// testPipeFlow 提交 submit_sm，等待 deliver_sm，然后优雅关闭会话
+func testPipeFlow(t *testing.T, conn Connection) {
+	received := make(chan pdu.PDU, 1)
+	sess, err := NewSession(conn, SessionConfig{
+		OnReceive: func(_ *Session, p pdu.PDU) pdu.PDU {
+			received <- p
+			return p.GetResponse()
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rp, err := sess.Call(ctx, pdu.NewSubmitSM())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := rp.(*pdu.SubmitSMResp).MessageID; id != "MSG1" {
+		t.Errorf("message id is %q, want MSG1", id)
+	}
+
+	select {
+	case p := <-received:
+		if _, ok := p.(*pdu.DeliverSM); !ok {
+			t.Errorf("received %T, want *pdu.DeliverSM", p)
+		}
+	case <-ctx.Done():
+		t.Fatal("deliver_sm is not received")
+	}
+
+	if err = sess.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if status := sess.Status(); status == SessionActive {
+		t.Errorf("session is still active after shutdown")
+	}
+}
+
+func TestPipeListener(t *testing.T) {
+	deliver := pdu.NewDeliverSM()
+
+	l := NewPipeListener()
+	server := NewServer(ServerConfig{
+		Connection:   ServerConnectionConfig{Authenticate: pipeAuthenticate},
+		SessionNewer: func(*ServerConnection) SessionConfig { return pipeServerConfig(deliver) },
+	})
+	served := make(chan error, 1)
+	go func() {
+		served <- server.Serve(l)
+	}()
+
+	testPipeFlow(t, NewClientConnection(ClientConnectionConfig{
+		Dial:     l.Dial,
+		SystemId: "user1",
+		Password: "user1",
+		BindType: pdu.Transceiver,
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("serve returns %v, want ErrServerClosed", err)
+	}
+	if _, err := l.Dial(""); err == nil {
+		t.Errorf("dial a closed listener succeeded")
+	}
+}
+
+func TestPipeConnections(t *testing.T) {
+	deliver := pdu.NewDeliverSM()
+
+	cc, sc := NewPipeConnections(
+		ClientConnectionConfig{SystemId: "user1", Password: "user1", BindType: pdu.Transceiver},
+		ServerConnectionConfig{Authenticate: pipeAuthenticate},
+	)
+	go func() {
+		_, _ = NewSession(sc, pipeServerConfig(deliver))
+	}()
+
+	testPipeFlow(t, cc)
+}
+
+func TestPipeConnectionsAuthFailed(t *testing.T) {
+	cc, sc := NewPipeConnections(
+		ClientConnectionConfig{SystemId: "user1", Password: "wrong", BindType: pdu.Transceiver},
+		ServerConnectionConfig{Authenticate: pipeAuthenticate},
+	)
+	go func() {
+		_, _ = NewSession(sc, SessionConfig{})
+	}()
+
+	if _, err := NewSession(cc, SessionConfig{}); err == nil {
+		t.Fatal("bind with a wrong password succeeded")
+	}
+}
@@ -0,0 +1,106 @@
+package smpp
+
+import (
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// SessionPool a group of client sessions bound with the same account, PDUs written to the
+// pool are routed to the least-loaded active session
+type SessionPool struct {
+	sessions []*Session
+}
+
+// NewSessionPool open n client sessions by the same connection config and session config
+func NewSessionPool(n int, cc ClientConnectionConfig, sc SessionConfig) (*SessionPool, error) {
+	p := &SessionPool{
+		sessions: make([]*Session, 0, n),
+	}
+
+	for i := 0; i < n; i++ {
+		sess, err := NewSession(NewClientConnection(cc), sc)
+		if err != nil {
+			p.Close()
+			return nil, err
+		}
+		p.sessions = append(p.sessions, sess)
+	}
+
+	return p, nil
+}
+
+// Sessions get all sessions of this pool
+func (p *SessionPool) Sessions() []*Session {
+	return p.sessions
+}
+
+// Pick get the active session with the least requests in its window, it returns nil if
+// there is no active session
+func (p *SessionPool) Pick() *Session {
+	var (
+		picked *Session
+		load   int
+	)
+	for _, sess := range p.sessions {
+		if sess.Status() != SessionActive {
+			continue
+		}
+		n := sess.InFlight()
+		if picked == nil || n < load {
+			picked, load = sess, n
+		}
+	}
+	return picked
+}
+
+// Write send a PDU by the least-loaded active session, see Session.Write
+func (p *SessionPool) Write(pd pdu.PDU, data any) error {
+	sess := p.Pick()
+	if sess == nil {
+		return ErrConnectionClosed
+	}
+	return sess.Write(pd, data)
+}
+
+// Submit send a PDU by the least-loaded active session, see Session.Submit
+func (p *SessionPool) Submit(pd pdu.PDU) (*Pending, error) {
+	sess := p.Pick()
+	if sess == nil {
+		return nil, ErrConnectionClosed
+	}
+	return sess.Submit(pd)
+}
+
+// Close close all sessions of this pool
+func (p *SessionPool) Close() {
+	for _, sess := range p.sessions {
+		sess.Close()
+	}
+}
+
+// Status get the aggregated status of sessions
+// SessionActive:  at least one session is active
+// SessionDialing: no session is active and at least one session is retrying to reconnect
+// SessionClosed:  all sessions have been closed completely
+func (p *SessionPool) Status() string {
+	status := SessionClosed
+	for _, sess := range p.sessions {
+		switch sess.Status() {
+		case SessionActive:
+			return SessionActive
+		case SessionDialing:
+			status = SessionDialing
+		}
+	}
+	return status
+}
+
+// CountActive get the count of active sessions
+func (p *SessionPool) CountActive() int {
+	n := 0
+	for _, sess := range p.sessions {
+		if sess.IsActive() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,206 @@
+package smpp
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// ConcatMessage a logical message reassembled from concatenated deliver_sm PDUs
+type ConcatMessage struct {
+	Source  string           // source address
+	Dest    string           // destination address
+	Ref     uint16           // reference number
+	Total   int              // total number of fragments
+	Text    string           // decoded UTF-8 text
+	Data    []byte           // raw message data
+	Pdus    []*pdu.DeliverSM // fragments in order, missing fragments are nil if Partial
+	Partial bool             // some fragments are missing, the fragments are expired or evicted before reassembled
+}
+
+// Reassembler buffer the fragments of concatenated deliver_sm PDUs which are marked by
+// concatenation UDH or SAR TLVs, and reassemble them into ConcatMessage
+type Reassembler struct {
+	wait  time.Duration           // 分片等待超时时间
+	size  int                     // 分片缓存的最大字节数
+	used  int                     // 分片缓存的字节数
+	parts map[string]*concatParts //
+	drops []*ConcatMessage        // 被淘汰的不完整消息，由 Expire 返回
+	mu    sync.Mutex              //
+}
+
+type concatParts struct {
+	ref    uint16
+	pdus   []*pdu.DeliverSM
+	datas  [][]byte
+	count  int
+	size   int
+	initAt time.Time
+}
+
+func NewReassembler(wait time.Duration, size int) *Reassembler {
+	return &Reassembler{
+		wait:  wait,
+		size:  size,
+		parts: make(map[string]*concatParts),
+	}
+}
+
+// Add add a deliver_sm PDU to the reassembler. It returns false if the PDU is not a fragment,
+// and returns the reassembled message when the last fragment is added
+func (r *Reassembler) Add(p *pdu.DeliverSM) (*ConcatMessage, bool) {
+	ref, total, seq, ok := ConcatInfo(p)
+	if !ok {
+		return nil, false
+	}
+
+	bs, _ := p.Message.GetMessageData()
+	key := fmt.Sprintf("%s|%s|%d|%d", p.SourceAddr.Address(), p.DestAddr.Address(), ref, total)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.expire(time.Now())
+
+	parts, ok := r.parts[key]
+	if !ok {
+		parts = &concatParts{
+			ref:    ref,
+			pdus:   make([]*pdu.DeliverSM, total),
+			datas:  make([][]byte, total),
+			initAt: time.Now(),
+		}
+		r.parts[key] = parts
+	}
+
+	// 重复的分片覆盖之前的分片
+	if parts.pdus[seq-1] == nil {
+		parts.count++
+	}
+	r.used -= len(parts.datas[seq-1])
+	parts.size -= len(parts.datas[seq-1])
+	parts.pdus[seq-1] = p
+	parts.datas[seq-1] = bs
+	r.used += len(bs)
+	parts.size += len(bs)
+
+	if parts.count < total {
+		r.evict(key)
+		return nil, true
+	}
+
+	delete(r.parts, key)
+	r.used -= parts.size
+
+	return parts.message(), true
+}
+
+// Expire remove the fragments that haven't been reassembled within the wait duration. It returns
+// them as partial messages, together with the partial messages evicted by Add since the last call
+func (r *Reassembler) Expire() []*ConcatMessage {
+	r.mu.Lock()
+	r.expire(time.Now())
+	drops := r.drops
+	r.drops = nil
+	r.mu.Unlock()
+
+	return drops
+}
+
+func (r *Reassembler) expire(curr time.Time) {
+	if r.wait <= 0 {
+		return
+	}
+
+	for key, parts := range r.parts {
+		if curr.Sub(parts.initAt) > r.wait {
+			r.drop(key)
+		}
+	}
+}
+
+// drop 删除未完成的分片，保留为不完整的消息
+func (r *Reassembler) drop(key string) {
+	parts := r.parts[key]
+	delete(r.parts, key)
+	r.used -= parts.size
+	r.drops = append(r.drops, parts.message())
+}
+
+func (r *Reassembler) evict(keep string) {
+	// 超出缓存上限时，优先丢弃最早的分片
+	for r.size > 0 && r.used > r.size {
+		oldest := ""
+		for key, parts := range r.parts {
+			if key != keep && (oldest == "" || parts.initAt.Before(r.parts[oldest].initAt)) {
+				oldest = key
+			}
+		}
+		if oldest == "" {
+			return
+		}
+		r.drop(oldest)
+	}
+}
+
+func (t *concatParts) message() *ConcatMessage {
+	bs := make([]byte, 0, t.size)
+	for _, d := range t.datas {
+		bs = append(bs, d...)
+	}
+
+	// 不完整的消息中第一个分片可能缺失
+	var first *pdu.DeliverSM
+	for _, p := range t.pdus {
+		if p != nil {
+			first = p
+			break
+		}
+	}
+	enc := first.Message.Encoding()
+	if enc == nil {
+		enc = data.GSM7BIT
+	}
+	text, _ := enc.Decode(bs)
+
+	return &ConcatMessage{
+		Source:  first.SourceAddr.Address(),
+		Dest:    first.DestAddr.Address(),
+		Ref:     t.ref,
+		Total:   len(t.pdus),
+		Text:    text,
+		Data:    bs,
+		Pdus:    t.pdus,
+		Partial: t.count < len(t.pdus),
+	}
+}
+
+// ConcatInfo get the reference number, total number and sequence number of a concatenated
+// deliver_sm PDU from its concatenation UDH or SAR TLVs
+func ConcatInfo(p *pdu.DeliverSM) (ref uint16, total int, seq int, ok bool) {
+	if udh := p.Message.UDH(); len(udh) > 0 {
+		if ie, found := udh.FindInfoElement(data.UDH_CONCAT_MSG_8_BIT_REF); found && len(ie.Data) == 3 {
+			ref, total, seq, ok = uint16(ie.Data[0]), int(ie.Data[1]), int(ie.Data[2]), true
+		} else if ie, found = udh.FindInfoElement(data.UDH_CONCAT_MSG_16_BIT_REF); found && len(ie.Data) == 4 {
+			ref, total, seq, ok = uint16(ie.Data[0])<<8|uint16(ie.Data[1]), int(ie.Data[2]), int(ie.Data[3]), true
+		}
+	}
+
+	if !ok {
+		f1, ok1 := p.OptionalParameters[pdu.TagSarMsgRefNum]
+		f2, ok2 := p.OptionalParameters[pdu.TagSarTotalSegments]
+		f3, ok3 := p.OptionalParameters[pdu.TagSarSegmentSeqnum]
+		if ok1 && ok2 && ok3 && len(f1.Data) == 2 && len(f2.Data) == 1 && len(f3.Data) == 1 {
+			ref, total, seq, ok = uint16(f1.Data[0])<<8|uint16(f1.Data[1]), int(f2.Data[0]), int(f3.Data[0]), true
+		}
+	}
+
+	if ok && (total < 2 || seq < 1 || seq > total) {
+		ok = false
+	}
+
+	return
+}
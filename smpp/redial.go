@@ -0,0 +1,58 @@
+package smpp
+
+import (
+	"math/rand/v2"
+	"time"
+)
+
+// RedialPolicy decide how long to wait before the attempt of redialing, the err is the error
+// of the previous attempt and is nil for the first attempt. It returns false to stop redialing
+type RedialPolicy interface {
+	Next(attempt int, err error) (time.Duration, bool)
+}
+
+// Backoff exponential backoff with jitter, it implements RedialPolicy
+type Backoff struct {
+	Min      time.Duration // the delay of the first attempt, default 1s
+	Max      time.Duration // the max delay, 0 means unlimited
+	Factor   float64       // the multiplier of the delay of each attempt, default 2
+	Jitter   float64       // randomize the delay by ±Jitter*delay, in range [0, 1]
+	Attempts int           // max attempts, 0 means unlimited
+}
+
+// Duration get the delay of the attempt, the attempt starts from 1
+func (b *Backoff) Duration(attempt int) time.Duration {
+	factor := b.Factor
+	if factor == 0 {
+		factor = 2
+	}
+	// 避免零延迟导致的重拨死循环
+	first := b.Min
+	if first <= 0 {
+		first = time.Second
+	}
+
+	d := float64(first)
+	for i := 1; i < attempt; i++ {
+		d *= factor
+		if b.Max > 0 && d >= float64(b.Max) {
+			break
+		}
+	}
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(d)
+}
+
+func (b *Backoff) Next(attempt int, _ error) (time.Duration, bool) {
+	if b.Attempts > 0 && attempt > b.Attempts {
+		return 0, false
+	}
+	return b.Duration(attempt), true
+}
@@ -0,0 +1,217 @@
+package smpp
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// ReplayConnection a fake Connection which replays a capture as the peer terminal. The inbound
+// records are returned by Read in order, and an inbound record is held until the outbound records
+// before it are written by the session. The written PDUs are matched to the outbound records by
+// command ID, unmatched PDUs such as enquire_link are ignored. The sequence numbers of inbound
+// responses are rewritten to the sequence numbers of the matched requests. The binds in the capture
+// are skipped, and frames which can not be parsed are returned by Read as errors
+type ReplayConnection struct {
+	conf     ReplayConnectionConfig
+	records  []*CaptureRecord
+	consumed []bool
+	pos      int             // 下一条未处理的记录
+	seqs     map[int32]int32 // 记录中请求的序列号 -> 会话实际使用的序列号
+	deadline time.Time
+	dialed   bool
+	closed   bool
+	done     chan struct{}
+	wake     chan struct{} // 状态变化时关闭，用于唤醒 Read
+	mu       sync.Mutex
+}
+
+type ReplayConnectionConfig struct {
+	Role     int // the role of the recorded session, default RoleClient
+	SystemId string
+	BindType pdu.BindingType
+}
+
+func NewReplayConnection(records []*CaptureRecord, conf ReplayConnectionConfig) *ReplayConnection {
+	if conf.Role == 0 {
+		conf.Role = RoleClient
+	}
+	c := &ReplayConnection{
+		conf:     conf,
+		records:  records,
+		consumed: make([]bool, len(records)),
+		seqs:     make(map[int32]int32),
+		done:     make(chan struct{}),
+		wake:     make(chan struct{}),
+	}
+
+	// 跳过绑定过程，以及无法识别的出站记录
+	for i, record := range records {
+		id, _, ok := captureHeader(record.Raw)
+		switch {
+		case !ok && record.Direction == TraceOut:
+			c.consumed[i] = true
+		case id == data.BIND_RECEIVER, id == data.BIND_TRANSMITTER, id == data.BIND_TRANSCEIVER, id == data.OUTBIND,
+			id == data.BIND_RECEIVER_RESP, id == data.BIND_TRANSMITTER_RESP, id == data.BIND_TRANSCEIVER_RESP:
+			c.consumed[i] = true
+		}
+	}
+	c.advance()
+	return c
+}
+
+func (c *ReplayConnection) Role() int {
+	return c.conf.Role
+}
+
+func (c *ReplayConnection) SelfAddr() string {
+	return "replay"
+}
+
+func (c *ReplayConnection) PeerAddr() string {
+	return "replay"
+}
+
+func (c *ReplayConnection) Deadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.notify()
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *ReplayConnection) SystemId() string {
+	return c.conf.SystemId
+}
+
+func (c *ReplayConnection) BindType() pdu.BindingType {
+	return c.conf.BindType
+}
+
+// Dial succeed only once, the replay can not be redialed
+func (c *ReplayConnection) Dial() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.dialed {
+		return ErrConnectionClosed
+	}
+	c.dialed = true
+	return nil
+}
+
+func (c *ReplayConnection) Read() (pdu.PDU, error) {
+	c.mu.Lock()
+	for {
+		if c.closed {
+			c.mu.Unlock()
+			return nil, io.EOF
+		}
+		if !c.deadline.IsZero() && !time.Now().Before(c.deadline) {
+			c.mu.Unlock()
+			return nil, os.ErrDeadlineExceeded
+		}
+
+		// 下一条记录是入站记录时返回
+		if c.pos < len(c.records) && c.records[c.pos].Direction == TraceIn {
+			record := c.records[c.pos]
+			c.consumed[c.pos] = true
+			c.advance()
+			p, err := record.Pdu()
+			if err == nil && isResponse(p.GetHeader().CommandID) {
+				if seq, ok := c.seqs[p.GetSequenceNumber()]; ok {
+					p.SetSequenceNumber(seq)
+				}
+			}
+			c.mu.Unlock()
+			return p, err
+		}
+
+		// 等待会话写入出站记录，或超时、关闭
+		wake := c.wake
+		var tm *time.Timer
+		var timeout <-chan time.Time
+		if !c.deadline.IsZero() {
+			tm = time.NewTimer(time.Until(c.deadline))
+			timeout = tm.C
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-wake:
+		case <-timeout:
+		}
+		if tm != nil {
+			tm.Stop()
+		}
+		c.mu.Lock()
+	}
+}
+
+func (c *ReplayConnection) Write(p pdu.PDU) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, ErrConnectionClosed
+	}
+
+	// 按命令匹配第一条未处理的出站记录
+	id := p.GetHeader().CommandID
+	for i := c.pos; i < len(c.records); i++ {
+		record := c.records[i]
+		if c.consumed[i] || record.Direction != TraceOut {
+			continue
+		}
+		rid, rseq, _ := captureHeader(record.Raw)
+		if rid != id {
+			continue
+		}
+		if p.CanResponse() {
+			c.seqs[rseq] = p.GetSequenceNumber()
+		}
+		c.consumed[i] = true
+		c.advance()
+		c.notify()
+		break
+	}
+
+	return int(p.GetHeader().CommandLength), nil
+}
+
+func (c *ReplayConnection) Close(bool) error {
+	c.mu.Lock()
+	c.closed = true
+	c.notify()
+	c.mu.Unlock()
+	return nil
+}
+
+// Done is closed when all records are replayed
+func (c *ReplayConnection) Done() <-chan struct{} {
+	return c.done
+}
+
+// advance 跳过已处理的记录，调用者需要持有锁
+func (c *ReplayConnection) advance() {
+	for c.pos < len(c.records) && c.consumed[c.pos] {
+		c.pos++
+	}
+	if c.pos == len(c.records) {
+		select {
+		case <-c.done:
+		default:
+			close(c.done)
+		}
+	}
+}
+
+// notify 唤醒等待中的 Read，调用者需要持有锁
+func (c *ReplayConnection) notify() {
+	close(c.wake)
+	c.wake = make(chan struct{})
+}
@@ -0,0 +1,157 @@
+package smpp
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+func TestReadCapture(t *testing.T) {
+	records := []*CaptureRecord{
+		{Time: time.Unix(0, 1), Direction: TraceOut, Raw: []byte{0, 0, 0, 16, 0, 0, 0, 21, 0, 0, 0, 0, 0, 0, 0, 1}},
+		{Time: time.Unix(0, 2), Direction: TraceIn, Raw: []byte{0, 0, 0, 8}}, // 无法解析的帧
+	}
+
+	for _, format := range []int{CaptureBinary, CaptureJsonl} {
+		var buf bytes.Buffer
+		w := NewCaptureWriter(&buf, format)
+		for _, record := range records {
+			if err := w.Write(record); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		got, err := ReadCapture(&buf, format)
+		if err != nil {
+			t.Fatalf("format %d: %v", format, err)
+		}
+		if len(got) != len(records) {
+			t.Fatalf("format %d: got %d records, want %d", format, len(got), len(records))
+		}
+		for i, record := range records {
+			if !got[i].Time.Equal(record.Time) || got[i].Direction != record.Direction || !bytes.Equal(got[i].Raw, record.Raw) {
+				t.Errorf("format %d: record %d is %+v, want %+v", format, i, got[i], record)
+			}
+		}
+	}
+}
+
+func TestReplayConnection(t *testing.T) {
+	submits := []pdu.PDU{pdu.NewSubmitSM(), pdu.NewSubmitSM()}
+	deliver := pdu.NewDeliverSM()
+
+	// 录制客户端会话：两次提交，服务端返回消息 ID，并下发一条 deliver_sm
+	var capture bytes.Buffer
+	cc, sc := NewPipeConnections(
+		ClientConnectionConfig{SystemId: "user1", Password: "user1", BindType: pdu.Transceiver},
+		ServerConnectionConfig{Authenticate: func(*ServerConnection, string, string) data.CommandStatusType { return data.ESME_ROK }},
+	)
+	go func() {
+		_, _ = NewSession(sc, SessionConfig{OnReceive: func(sess *Session, p pdu.PDU) pdu.PDU {
+			rp := p.GetResponse()
+			if sr, ok := rp.(*pdu.SubmitSMResp); ok {
+				sr.MessageID = "MSG1"
+				if p.GetSequenceNumber() == submits[1].GetSequenceNumber() {
+					_ = sess.Write(deliver, nil)
+				}
+			}
+			return rp
+		}})
+	}()
+	delivered := make(chan struct{})
+	closed := make(chan struct{})
+	sess, err := NewSession(NewRecordConnection(cc, NewCaptureWriter(&capture, CaptureBinary)), SessionConfig{
+		OnReceive: func(_ *Session, p pdu.PDU) pdu.PDU {
+			close(delivered)
+			return p.GetResponse()
+		},
+		OnClosed: func(*Session, string, string) {
+			close(closed)
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, p := range submits {
+		if _, err = sess.Call(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-delivered:
+	case <-ctx.Done():
+		t.Fatal("deliver_sm is not received")
+	}
+	if err = sess.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-closed // 等待所有协程退出，之后不再写入 capture
+
+	records, err := ReadCapture(&capture, CaptureBinary)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 回放：会话使用不同的序列号提交，响应与 deliver_sm 按录制顺序到达
+	conn := NewReplayConnection(records, ReplayConnectionConfig{SystemId: "user1", BindType: pdu.Transceiver})
+	responses := make(chan *Response, len(submits))
+	received := make(chan pdu.PDU, 1)
+	replay, err := NewSession(conn, SessionConfig{
+		WindowWait: 100 * time.Millisecond,
+		OnReceive: func(_ *Session, p pdu.PDU) pdu.PDU {
+			received <- p
+			return p.GetResponse()
+		},
+		OnRespond: func(_ *Session, response *Response) {
+			if _, ok := response.Request.Pdu.(*pdu.SubmitSM); ok {
+				responses <- response
+			}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = replay.Write(pdu.NewEnquireLink(), nil); err != nil { // 未录制的 pdu 被忽略，并且之后的序列号与录制时不同
+		t.Fatal(err)
+	}
+	for range submits {
+		if err = replay.Write(pdu.NewSubmitSM(), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for range submits {
+		select {
+		case response := <-responses:
+			if response.Error != nil {
+				t.Fatal(response.Error)
+			}
+			if id := response.Pdu.(*pdu.SubmitSMResp).MessageID; id != "MSG1" {
+				t.Errorf("message id is %q, want MSG1", id)
+			}
+		case <-ctx.Done():
+			t.Fatal("submit_sm_resp is not replayed")
+		}
+	}
+	select {
+	case p := <-received:
+		if _, ok := p.(*pdu.DeliverSM); !ok {
+			t.Errorf("received %T, want *pdu.DeliverSM", p)
+		}
+	case <-ctx.Done():
+		t.Fatal("deliver_sm is not replayed")
+	}
+	if err = replay.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-conn.Done():
+	case <-ctx.Done():
+		t.Fatal("capture is not replayed completely")
+	}
+}
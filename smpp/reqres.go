@@ -1,6 +1,8 @@
 package smpp
 
 import (
+	"time"
+
 	"github.com/linxGnu/gosmpp/pdu"
 )
 
@@ -11,11 +13,36 @@ type Request struct {
 	// trace info
 	SessionId string
 	SystemId  string
-	SubmitAt  int64
+	SubmitAt  int64 // unix nano time of sending the pdu
 	TraceData any
 
+	// the response timeout of this request, 0 means using SessionConfig.WindowWait
+	Timeout time.Duration
+
 	// mark the submitter
 	submitter int8
+
+	// the count of resubmissions by RetryPolicy
+	retries int
+
+	// the time of creating the request
+	createAt time.Time
+
+	// the handle of request submitted by Session.Submit
+	pending *Pending
+}
+
+// ExpireAt get the unix nano deadline of the request, wait is used if Timeout is not set
+func (r *Request) ExpireAt(wait time.Duration) int64 {
+	if r.Timeout > 0 {
+		wait = r.Timeout
+	}
+	return r.SubmitAt + int64(wait)
+}
+
+// canceled 请求是否已被 Pending.Cancel 取消
+func (r *Request) canceled() bool {
+	return r.pending != nil && r.pending.resolved()
 }
 
 type Response struct {
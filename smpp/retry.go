@@ -0,0 +1,43 @@
+package smpp
+
+import (
+	"slices"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+)
+
+// RetryPolicy decide whether to resubmit a request whose response has the command status, and
+// how long to wait before resubmitting. The attempt starts from 1 for the first retry
+type RetryPolicy interface {
+	Retry(attempt int, status data.CommandStatusType) (time.Duration, bool)
+}
+
+// RetryStatuses the default command statuses of StatusRetry
+var RetryStatuses = []data.CommandStatusType{
+	data.ESME_RTHROTTLED,
+	data.ESME_RMSGQFUL,
+	data.ESME_RSYSERR,
+}
+
+// StatusRetry retry the requests whose responses have one of Statuses, it implements RetryPolicy
+type StatusRetry struct {
+	Statuses []data.CommandStatusType // the command statuses to retry, default RetryStatuses
+	Backoff  Backoff                  // the delay of each retry, Backoff.Attempts is the max retries, default 3, negative means unlimited
+}
+
+func (r *StatusRetry) Retry(attempt int, status data.CommandStatusType) (time.Duration, bool) {
+	statuses := r.Statuses
+	if statuses == nil {
+		statuses = RetryStatuses
+	}
+	if !slices.Contains(statuses, status) {
+		return 0, false
+	}
+	// 默认有限次重试，防止一直失败的请求被无限重发且永远不通知结果
+	backoff := r.Backoff
+	if backoff.Attempts == 0 {
+		backoff.Attempts = 3
+	}
+	return backoff.Next(attempt, nil)
+}
@@ -0,0 +1,251 @@
+package smpp
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+const (
+	SplitByUdh8    = 0 // concatenation UDH with 8-bit reference number
+	SplitByUdh16   = 1 // concatenation UDH with 16-bit reference number
+	SplitBySar     = 2 // sar_msg_ref_num, sar_total_segments and sar_segment_seqnum TLVs
+	SplitByPayload = 3 // a single message_payload TLV
+)
+
+var _segmentRef uint32
+
+// SplitMessage split a long text into submit_sm PDUs, all fields except the message of the
+// PDUs are copied from p. The text is encoded in GSM-7bit if possible, otherwise in UCS-2
+func SplitMessage(p *pdu.SubmitSM, text string, mode int) ([]*pdu.SubmitSM, error) {
+	_, _, isGsm := DetectMessage(text)
+	enc := data.UCS2
+	if isGsm {
+		enc = data.GSM7BIT
+	}
+
+	// 整条消息放在 message_payload 中
+	if mode == SplitByPayload {
+		bs, err := enc.Encode(text)
+		if err != nil {
+			return nil, err
+		}
+		sp := cloneSubmitSm(p)
+		_ = sp.Message.SetMessageDataWithEncoding([]byte{}, enc)
+		sp.RegisterOptionalParam(pdu.Field{Tag: pdu.TagMessagePayload, Data: bs})
+		return []*pdu.SubmitSM{sp}, nil
+	}
+
+	// 按分片长度切分消息
+	segments := splitText(text, isGsm, mode == SplitByUdh16)
+	if len(segments) > 255 { // 分片总数和序号只有一个字节
+		return nil, ErrTooManySegments
+	}
+	ref := uint16(atomic.AddUint32(&_segmentRef, 1))
+	total := len(segments)
+
+	pdus := make([]*pdu.SubmitSM, 0, total)
+	for i, segment := range segments {
+		bs, err := enc.Encode(segment)
+		if err != nil {
+			return nil, err
+		}
+
+		sp := cloneSubmitSm(p)
+		if err = sp.Message.SetMessageDataWithEncoding(bs, enc); err != nil {
+			return nil, err
+		}
+
+		if total > 1 {
+			seq := byte(i + 1)
+			switch mode {
+			case SplitByUdh16:
+				sp.EsmClass |= data.SM_UDH_GSM
+				sp.Message.SetUDH(pdu.UDH{pdu.InfoElement{
+					ID:   data.UDH_CONCAT_MSG_16_BIT_REF,
+					Data: []byte{byte(ref >> 8), byte(ref), byte(total), seq},
+				}})
+			case SplitBySar:
+				sp.RegisterOptionalParam(pdu.Field{Tag: pdu.TagSarMsgRefNum, Data: []byte{byte(ref >> 8), byte(ref)}})
+				sp.RegisterOptionalParam(pdu.Field{Tag: pdu.TagSarTotalSegments, Data: []byte{byte(total)}})
+				sp.RegisterOptionalParam(pdu.Field{Tag: pdu.TagSarSegmentSeqnum, Data: []byte{seq}})
+			default:
+				sp.EsmClass |= data.SM_UDH_GSM
+				sp.Message.SetUDH(pdu.UDH{pdu.NewIEConcatMessage(byte(total), seq, byte(ref))})
+			}
+		}
+
+		pdus = append(pdus, sp)
+	}
+
+	return pdus, nil
+}
+
+func splitText(text string, isGsm bool, ref16 bool) []string {
+	// GSM-7bit 扩展字符占 2 个字符位，UCS-2 中 BMP 以外的字符占 2 个编码单元
+	maxLen, segLen := 70, 67
+	if isGsm {
+		maxLen, segLen = 160, 153
+	}
+	if ref16 {
+		segLen--
+	}
+
+	weight := func(r rune) int {
+		if isGsm {
+			if IsGsm7bitExtraChar(r) {
+				return 2
+			}
+			return 1
+		}
+		if r > 0xFFFF {
+			return 2
+		}
+		return 1
+	}
+
+	msgLen := 0
+	for _, r := range text {
+		msgLen += weight(r)
+	}
+	if msgLen <= maxLen {
+		return []string{text}
+	}
+
+	segments := make([]string, 0, msgLen/segLen+1)
+	runes := []rune(text)
+	start, n := 0, 0
+	for i, r := range runes {
+		w := weight(r)
+		if n+w > segLen {
+			segments = append(segments, string(runes[start:i]))
+			start, n = i, 0
+		}
+		n += w
+	}
+	segments = append(segments, string(runes[start:]))
+
+	return segments
+}
+
+func cloneSubmitSm(p *pdu.SubmitSM) *pdu.SubmitSM {
+	c := pdu.NewSubmitSM().(*pdu.SubmitSM)
+	c.ServiceType = p.ServiceType
+	c.SourceAddr = p.SourceAddr
+	c.DestAddr = p.DestAddr
+	c.EsmClass = p.EsmClass
+	c.ProtocolID = p.ProtocolID
+	c.PriorityFlag = p.PriorityFlag
+	c.ScheduleDeliveryTime = p.ScheduleDeliveryTime
+	c.ValidityPeriod = p.ValidityPeriod
+	c.RegisteredDelivery = p.RegisteredDelivery
+	c.ReplaceIfPresentFlag = p.ReplaceIfPresentFlag
+	for _, field := range p.OptionalParameters {
+		c.RegisterOptionalParam(field)
+	}
+	return c
+}
+
+// LongResponse the aggregate response of the segments submitted by Session.WriteLong
+type LongResponse struct {
+	Responses []*Response // responses of segments in order
+}
+
+// Err get the first error of segments, a segment responded with a non-zero command status
+// is regarded as failed with StatusError
+func (r *LongResponse) Err() error {
+	for _, response := range r.Responses {
+		if err := segmentError(response); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func segmentError(response *Response) error {
+	if response.Error != nil {
+		return response.Error
+	}
+	if status := response.Pdu.GetHeader().CommandStatus; status != data.ESME_ROK {
+		return NewStatusError(status)
+	}
+	return nil
+}
+
+// MessageIds get the message IDs of segments, the ID of failed segment is empty
+func (r *LongResponse) MessageIds() []string {
+	ids := make([]string, len(r.Responses))
+	for i, response := range r.Responses {
+		if rp, ok := response.Pdu.(*pdu.SubmitSMResp); ok {
+			ids[i] = rp.MessageID
+		}
+	}
+	return ids
+}
+
+// WriteLong split a long text into submit_sm PDUs by SplitMessage, send them to peer terminal
+// and wait until all of them are responded. Once a segment fails, the other segments are canceled
+// with ErrRequestCanceled, the queued ones are not sent. The returned error is the error of
+// splitting or ctx, the failure of segments is indicated by LongResponse.Err()
+func (s *Session) WriteLong(ctx context.Context, p *pdu.SubmitSM, text string, mode int) (*LongResponse, error) {
+	pdus, err := SplitMessage(p, text, mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提交所有分片，分片失败时停止提交，对端无法组合出完整的消息
+	pendings := make([]*Pending, 0, len(pdus))
+	for _, sp := range pdus {
+		if failedPending(pendings) {
+			break
+		}
+		pending, err2 := s.submit(ctx, sp)
+		if err2 != nil {
+			pending = newPending(s.newRequest(SubmitByUsr, sp, nil))
+			pending.resolve(NewResponse(pending.request, nil, err2))
+			pendings = append(pendings, pending)
+			break
+		}
+		pendings = append(pendings, pending)
+	}
+	if len(pendings) < len(pdus) {
+		for _, sp := range pdus[len(pendings):] {
+			pendings = append(pendings, newPending(s.newRequest(SubmitByUsr, sp, nil)))
+		}
+		cancelPendings(pendings)
+	}
+
+	// 等待所有分片的响应，分片失败时取消其余分片
+	response := &LongResponse{Responses: make([]*Response, 0, len(pendings))}
+	for _, pending := range pendings {
+		resp, err2 := pending.Wait(ctx)
+		if err2 != nil {
+			cancelPendings(pendings)
+			return nil, err2
+		}
+		if segmentError(resp) != nil {
+			cancelPendings(pendings)
+		}
+		response.Responses = append(response.Responses, resp)
+	}
+
+	return response, nil
+}
+
+// failedPending 是否有已经失败的分片
+func failedPending(pendings []*Pending) bool {
+	for _, pending := range pendings {
+		if response := pending.Response(); response != nil && segmentError(response) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func cancelPendings(pendings []*Pending) {
+	for _, pending := range pendings {
+		pending.Cancel()
+	}
+}
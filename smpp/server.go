@@ -0,0 +1,241 @@
+package smpp
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Server struct {
+	conf     ServerConfig        //
+	listen   net.Listener        //
+	sessions map[string]*Session // 已绑定的会话
+	slots    chan struct{}       // 连接数限制
+	done     chan struct{}       //
+	closed   int32               // 服务是否已关闭
+	mu       sync.Mutex          //
+}
+
+type ServerConfig struct {
+	Addr         string                                // listen address
+	MaxConns     int                                   // max concurrent connections, 0 means unlimited
+	Connection   ServerConnectionConfig                // config of each server connection
+	SessionNewer func(*ServerConnection) SessionConfig // create the session config of each connection
+}
+
+func NewServer(conf ServerConfig) *Server {
+	s := &Server{
+		conf:     conf,
+		sessions: make(map[string]*Session),
+		done:     make(chan struct{}),
+	}
+	if conf.MaxConns > 0 {
+		s.slots = make(chan struct{}, conf.MaxConns)
+	}
+	return s
+}
+
+// ListenAndServe listen on the TCP address ServerConfig.Addr and serve the connections
+func (s *Server) ListenAndServe() error {
+	listen, err := net.Listen("tcp", s.conf.Addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(listen)
+}
+
+// ListenAndServeTLS like ListenAndServe, but the connections are served over TLS
+func (s *Server) ListenAndServeTLS(certFile string, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	listen, err := tls.Listen("tcp", s.conf.Addr, &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		return err
+	}
+	return s.Serve(listen)
+}
+
+// Serve accept connections on the listener and create a session for each connection, it
+// always returns a non-nil error, and returns ErrServerClosed after Shutdown
+func (s *Server) Serve(listen net.Listener) error {
+	s.mu.Lock()
+	if s.isClosed() {
+		s.mu.Unlock()
+		_ = listen.Close()
+		return ErrServerClosed
+	}
+	s.listen = listen
+	s.mu.Unlock()
+
+	s.info("Serving", "listen_addr", listen.Addr().String())
+
+	for {
+		if !s.acquire() {
+			return ErrServerClosed
+		}
+
+		conn, err := listen.Accept()
+		if err != nil {
+			s.release()
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			if nerr, ok := err.(net.Error); ok && nerr.Timeout() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	sc := NewServerConnection(conn, s.conf.Connection)
+
+	// 创建会话配置，服务端会话不能重连
+	conf := SessionConfig{}
+	if s.conf.SessionNewer != nil {
+		conf = s.conf.SessionNewer(sc)
+	}
+	conf.AttemptDial = 0
+	conf.RedialPolicy = nil
+	onClosed := conf.OnClosed
+	conf.OnClosed = func(sess *Session, reason string, desc string) {
+		s.mu.Lock()
+		_, ok := s.sessions[sess.Id()]
+		delete(s.sessions, sess.Id())
+		s.mu.Unlock()
+		if ok {
+			s.release()
+		}
+		if onClosed != nil {
+			onClosed(sess, reason, desc)
+		}
+	}
+
+	sess, err := NewSession(sc, conf)
+	if err != nil {
+		s.warn("Create session failed", "peer_addr", conn.RemoteAddr().String(), "error", err)
+		s.release()
+		return
+	}
+
+	// 会话可能在创建后立即关闭，此时 OnClosed 不会找到该会话
+	s.mu.Lock()
+	added := !sess.Closed()
+	if added {
+		s.sessions[sess.Id()] = sess
+	}
+	s.mu.Unlock()
+	if !added {
+		s.release()
+		return
+	}
+
+	// 服务在会话创建期间被关闭
+	if s.isClosed() {
+		sess.Close()
+	}
+}
+
+func (s *Server) acquire() bool {
+	if s.slots == nil {
+		return !s.isClosed()
+	}
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-s.done:
+		return false
+	}
+}
+
+func (s *Server) release() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+func (s *Server) isClosed() bool {
+	return atomic.LoadInt32(&s.closed) == 1
+}
+
+func (s *Server) info(m string, fields ...any) {
+	if _log != nil {
+		_log.Info(m, append([]any{"server", s.conf.Addr}, fields...)...)
+	}
+}
+
+func (s *Server) warn(m string, fields ...any) {
+	if _log != nil {
+		_log.Warn(m, append([]any{"server", s.conf.Addr}, fields...)...)
+	}
+}
+
+// Sessions get the bound sessions of this server
+func (s *Server) Sessions() []*Session {
+	s.mu.Lock()
+	sessions := make([]*Session, 0, len(s.sessions))
+	for _, sess := range s.sessions {
+		sessions = append(sessions, sess)
+	}
+	s.mu.Unlock()
+
+	return sessions
+}
+
+// CountSessions get the count of bound sessions of this server
+func (s *Server) CountSessions() int {
+	s.mu.Lock()
+	n := len(s.sessions)
+	s.mu.Unlock()
+
+	return n
+}
+
+// Shutdown stop accepting new connections, wait for the window of every bound session to
+// drain, then unbind and close the sessions. It returns the error of ctx if ctx is done
+// before all sessions are closed
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	if !atomic.CompareAndSwapInt32(&s.closed, 0, 1) {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	close(s.done)
+	if s.listen != nil {
+		_ = s.listen.Close()
+	}
+	s.mu.Unlock()
+
+	s.info("Shutting down")
+
+	// 等待窗口中的请求处理完成后解绑并关闭会话
+	for _, sess := range s.Sessions() {
+		go func(sess *Session) {
+			_ = sess.Shutdown(ctx)
+		}(sess)
+	}
+
+	// 等待所有会话关闭
+	tk := time.NewTicker(50 * time.Millisecond)
+	defer tk.Stop()
+	for s.CountSessions() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-tk.C:
+		}
+	}
+
+	s.info("Shutdown")
+
+	return nil
+}
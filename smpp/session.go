@@ -2,15 +2,15 @@ package smpp
 
 import (
 	"context"
-	"fmt"
 	"net"
 	"runtime"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"github.com/linxGnu/gosmpp/data"
 	"github.com/linxGnu/gosmpp/pdu"
-	"github.com/sirupsen/logrus"
 
 	"github.com/yyliziqiu/smpp/libs/xuid"
 )
@@ -26,49 +26,80 @@ const (
 	SessionActive  = "active"
 	SessionClosed  = "closed"
 
-	CloseByError    = "error"
-	CloseByPdu      = "pdu"
-	CloseByExplicit = "explicit"
+	CloseByError     = "error"
+	CloseByPdu       = "pdu"
+	CloseByExplicit  = "explicit"
+	CloseByRedial    = "redial"
+	CloseByAuth      = "auth"
+	CloseByHeartbeat = "heartbeat"
+	CloseByIdle      = "idle"
+	CloseByShutdown  = "shutdown"
 )
 
 type Session struct {
 	id      string         //
-	slog    *logrus.Logger //
+	log     Logger         //
 	store   *SessionStore  //
 	conn    Connection     //
 	conf    *SessionConfig //
 	term    *SessionTerm   //
+	reasm   *Reassembler   // 长短信重组器
+	linkRtt int64          // 最近一次心跳的往返时间
+	seqNum  int32          // 会话自身的 pdu 序列号
+	held    []*Request     // 链接断开时保留的请求，重连后重新发送
 	pending int32          // 正在发送的请求数量
+	queued  int32          // 已放入 reqCh 但还未放入窗口或通知结果的请求数量
+	flight  int32          // 窗口中等待响应的请求数量
 	status  int32          // 连接状态
 	closed  int32          // 会话是否被显示关闭
+	drained int32          // 会话是否正在优雅关闭，不再接受新的请求
 	initAt  time.Time      // 会话创建时间
 }
 
 type SessionTerm struct {
-	swg    sync.WaitGroup
-	ctx    context.Context
-	cancel context.CancelFunc
-	window Window
-	pduCh  chan pdu.PDU
-	reqCh  chan *Request
-	dialAt time.Time
+	swg     sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	window  Window
+	pduCh   chan pdu.PDU
+	reqCh   chan *Request
+	expCh   chan struct{} // 窗口中出现更早的超时时间时，通知 loopClear 重新计时
+	expAt   int64         // loopClear 下一次检查超时的时间
+	unsent  *Request      // 链接关闭时 send 正在等待发送的请求，由 close 保留或通知失败
+	dialAt  time.Time
+	misses  int32 // 连续未响应的心跳数量
+	readAt  int64 // 最近一次读取 pdu 的时间
+	writeAt int64 // 最近一次写入 pdu 的时间
 }
 
 type SessionConfig struct {
-	Context     any                             // user custom data
-	EnquireLink time.Duration                   // heart beat interval
-	AttemptDial time.Duration                   // reconnection waiting time
-	WindowType  int                             // SMPP window type
-	WindowSize  int                             // SMPP window size
-	WindowWait  time.Duration                   // the timeout duration of request in the window
-	WindowScan  time.Duration                   // clearing window interval
-	WindowBlock time.Duration                   // block behavior when window is full. 0: return error immediately, >0: sleep WindowBlock duration, <0: hang up and wait next goroutine schedule
-	WindowNewer func(*Session) Window           // set custom window
-	OnDialed    func(*Session)                  // invoked when connection is established
-	OnClosed    func(*Session, string, string)  // invoked when session is closed completely
-	OnReceive   func(*Session, pdu.PDU) pdu.PDU // invoked when received a non-responsive PDU form peer terminal
-	OnRequest   func(*Session, *Request)        // invoked when submitted a PDU
-	OnRespond   func(*Session, *Response)       // invoked when received a responsive PDU of submitted PDU
+	Context      any                             // user custom data
+	EnquireLink  time.Duration                   // heart beat interval
+	EnquireMiss  int                             // close the connection when the count of consecutive unanswered heart beats reaches it, any received PDU resets the count, heart beats are skipped while the window is full, 0 means unlimited
+	EnquireIdle  bool                            // send heart beat only when there is no traffic in either direction for EnquireLink
+	IdleTimeout  time.Duration                   // server side only, unbind and close the connection when no PDU is received for IdleTimeout, 0 means disabled
+	AttemptDial  time.Duration                   // reconnection waiting time
+	RedialPolicy RedialPolicy                    // reconnection policy, default is redialing every AttemptDial
+	WindowType   int                             // SMPP window type, 0: SmallWindow, 1: LargeWindow, 2: AdaptiveWindow
+	WindowSize   int                             // SMPP window size
+	WindowWait   time.Duration                   // the timeout duration of request in the window
+	WindowScan   time.Duration                   // the max interval of clearing window and expired concatenated fragments, timed-out requests are cleared at their deadlines
+	WindowBlock  time.Duration                   // block behavior when window is full. 0: return error immediately, otherwise: wait until a response or timeout frees the window, windows without Acquire are polled every WindowBlock (<0: every goroutine schedule)
+	WindowNewer  func(*Session) Window           // set custom window
+	Limiter      *Limiter                        // limit the rate of PDUs submitted by user, share it among sessions to limit their total rate
+	RecoverAge   time.Duration                   // hold the queued and in-flight requests of a lost connection and resubmit them after redialing if they are younger than RecoverAge, 0 means failing them
+	Logger       Logger                          // the logger of the session, default is set by SetLog or SetLogger
+	Metrics      Metrics                         // collect metrics of the session, share one Collector among sessions to expose them together
+	RetryPolicy  RetryPolicy                     // resubmit PDUs submitted by user by the command status of responses, only the final response is passed to OnRespond
+	OnDialed     func(*Session)                  // invoked when connection is established
+	OnClosed     func(*Session, string, string)  // invoked when session is closed completely
+	OnRedial     func(*Session, int, error)      // invoked after each attempt of redialing, the error is nil if succeeded
+	OnReceive    func(*Session, pdu.PDU) pdu.PDU // invoked when received a non-responsive PDU form peer terminal
+	OnRequest    func(*Session, *Request)        // invoked when submitted a PDU
+	OnRespond    func(*Session, *Response)       // invoked when received a responsive PDU of submitted PDU
+	OnConcat     func(*Session, *ConcatMessage)  // enable reassembling concatenated deliver_sm, invoked when all fragments are received, or with a partial message when fragments are expired or evicted
+	ConcatWait   time.Duration                   // the timeout duration of reassembling concatenated deliver_sm
+	ConcatSize   int                             // the max bytes of buffered fragments
 }
 
 func NewSession(conn Connection, cfg SessionConfig) (*Session, error) {
@@ -77,6 +108,9 @@ func NewSession(conn Connection, cfg SessionConfig) (*Session, error) {
 	if conf.WindowNewer == nil {
 		conf.WindowNewer = CreateWindow
 	}
+	if conf.RedialPolicy == nil && conf.AttemptDial > 0 {
+		conf.RedialPolicy = &Backoff{Min: conf.AttemptDial, Max: conf.AttemptDial}
+	}
 	if conf.WindowSize == 0 {
 		conf.WindowSize = 32
 	}
@@ -86,11 +120,20 @@ func NewSession(conn Connection, cfg SessionConfig) (*Session, error) {
 	if conf.WindowScan == 0 {
 		conf.WindowScan = 30 * time.Second
 	}
+	if conf.ConcatWait == 0 {
+		conf.ConcatWait = 60 * time.Second
+	}
+	if conf.ConcatSize == 0 {
+		conf.ConcatSize = 1 << 20
+	}
+	if conf.Logger == nil {
+		conf.Logger = _log
+	}
 
 	// 创建会话
 	s := &Session{
 		id:     xuid.Get(),
-		slog:   _slog,
+		log:    conf.Logger,
 		store:  _store,
 		conn:   conn,
 		conf:   &conf,
@@ -99,6 +142,9 @@ func NewSession(conn Connection, cfg SessionConfig) (*Session, error) {
 		closed: 0,
 		initAt: time.Now(),
 	}
+	if conf.OnConcat != nil {
+		s.reasm = NewReassembler(conf.ConcatWait, conf.ConcatSize)
+	}
 
 	// 建立链接
 	if err := s.dial(); err != nil {
@@ -114,19 +160,22 @@ func (s *Session) dial() error {
 	}
 
 	if err := s.conn.Dial(); err != nil {
-		s.warn("Dial failed, peer addr: %s, error: %v", s.PeerAddr(), err)
+		s.warn("Dial failed", "error", err)
 		return err
 	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	s.term = &SessionTerm{
-		swg:    sync.WaitGroup{},
-		ctx:    ctx,
-		cancel: cancel,
-		window: s.conf.WindowNewer(s),
-		pduCh:  make(chan pdu.PDU, 16), // 必须带缓冲队列，防止 loopWrite 比 loopRead 先结束导致 loopRead 阻塞在 pduCh<- 处
-		reqCh:  make(chan *Request, 1),
-		dialAt: time.Now(),
+		swg:     sync.WaitGroup{},
+		ctx:     ctx,
+		cancel:  cancel,
+		window:  s.conf.WindowNewer(s),
+		pduCh:   make(chan pdu.PDU, 16), // 必须带缓冲队列，防止 loopWrite 比 loopRead 先结束导致 loopRead 阻塞在 pduCh<- 处
+		reqCh:   make(chan *Request, 1),
+		expCh:   make(chan struct{}, 1),
+		dialAt:  time.Now(),
+		readAt:  time.Now().UnixNano(),
+		writeAt: time.Now().UnixNano(),
 	}
 	s.term.swg.Add(4)
 
@@ -137,7 +186,7 @@ func (s *Session) dial() error {
 	go s.loopSend()
 	go s.loopClear()
 
-	s.info("Dialed, peer addr: %s", s.PeerAddr())
+	s.info("Dialed")
 	s.onDialed()
 
 	return nil
@@ -149,7 +198,10 @@ func (s *Session) close(reason string, desc string) {
 	}
 
 	go func() {
-		s.info("Closing, reason: %s, desc: %s", reason, desc)
+		s.info("Closing", "reason", reason, "desc", desc)
+		if s.conf.Metrics != nil {
+			s.conf.Metrics.ConnClosed(s, reason)
+		}
 
 		// 停止读写协程
 		s.term.cancel()
@@ -163,31 +215,51 @@ func (s *Session) close(reason string, desc string) {
 		s.debug("All goroutines done")
 
 		// 关闭链接
-		_ = s.conn.Close(reason == CloseByExplicit)
+		_ = s.conn.Close(reason == CloseByExplicit || reason == CloseByIdle)
+
+		// 结束会话或重连后恢复请求
+		closed := s.conf.RedialPolicy == nil || reason == CloseByExplicit || reason == CloseByShutdown
+		holding := !closed && s.conf.RecoverAge > 0
+
+		// send 中未发送的请求先于通道中的请求
+		if s.term.unsent != nil {
+			s.hold(s.term.unsent, holding, ErrConnectionClosed)
+			s.term.unsent = nil
+		}
 
-		// 清理通道
-		for atomic.LoadInt32(&s.pending) > 0 {
+		// 清理通道，pushRequest 返回后请求可能仍在通道缓冲中，所以至少排空一次
+		for {
+			done := atomic.LoadInt32(&s.pending) == 0 // 先于排空读取，之后不会再有请求写入通道
 			drain := true
 			for drain {
 				select {
 				case req := <-s.term.reqCh:
-					s.onRespond(NewResponse(req, nil, ErrConnectionClosed))
+					atomic.AddInt32(&s.queued, -1)
+					s.hold(req, holding, ErrConnectionClosed)
 				default:
 					drain = false
 				}
 			}
 			s.debug("Drained request channel")
+			if done {
+				break
+			}
 			time.Sleep(50 * time.Millisecond)
 		}
 		close(s.term.pduCh)
 		close(s.term.reqCh)
 
+		// 通知窗口中等待响应的请求，它们已发送但结果未知
+		for _, request := range s.term.window.Data() {
+			s.hold(request, holding, ErrConnectionLost)
+		}
+
 		// 删除窗口
 		s.term.window = nil
+		atomic.StoreInt32(&s.flight, 0)
 		s.info("Closed")
 
 		// 结束会话
-		closed := s.conf.AttemptDial == 0 || reason == CloseByExplicit
 		if closed {
 			s.onClosed(reason, desc)
 			return
@@ -195,17 +267,34 @@ func (s *Session) close(reason string, desc string) {
 
 		// 重新启动会话
 		s.info("Redialing")
-		ticker := time.NewTicker(s.conf.AttemptDial)
-		defer ticker.Stop()
-		for {
-			<-ticker.C
+		var err error
+		for attempt := 1; ; attempt++ {
+			wait, ok := s.conf.RedialPolicy.Next(attempt, err)
+			if !ok {
+				s.info("Stop redialing", "attempts", attempt-1)
+				atomic.StoreInt32(&s.closed, 1)
+				s.failHeld()
+				s.onClosed(CloseByRedial, errorString(err))
+				return
+			}
+			time.Sleep(wait)
 			if atomic.LoadInt32(&s.closed) == 1 {
 				s.info("Close when redialing")
+				s.failHeld()
 				s.onClosed(CloseByExplicit, "")
 				return
 			}
-			err := s.dial()
+			err = s.dial()
+			s.onRedial(attempt, err)
+			if IsPermanentError(err) {
+				s.info("Stop redialing, bind failed permanently", "error", err)
+				atomic.StoreInt32(&s.closed, 1)
+				s.failHeld()
+				s.onClosed(CloseByAuth, err.Error())
+				return
+			}
 			if err == nil {
+				s.recoverHeld()
 				if atomic.LoadInt32(&s.closed) == 1 {
 					s.info("Close when redialed")
 					s.close(CloseByExplicit, "")
@@ -233,10 +322,15 @@ func (s *Session) loopRead() {
 func (s *Session) read() bool {
 	p, err := s.conn.Read()
 	if err != nil {
-		s.warn("Read failed, error: %v", err)
+		s.warn("Read failed", "error", err)
 		s.close(CloseByError, err.Error())
 		return true
 	}
+	atomic.StoreInt64(&s.term.readAt, time.Now().UnixNano())
+	atomic.StoreInt32(&s.term.misses, 0) // 收到任何 pdu 都说明对端存活
+	if s.conf.Metrics != nil {
+		s.conf.Metrics.PduReceived(s, p)
+	}
 
 	if !s.allowRead(p) {
 		return false
@@ -244,28 +338,40 @@ func (s *Session) read() bool {
 
 	switch p.(type) {
 	case *pdu.EnquireLink:
-		s.debug("Received enquire link pdu")
+		s.debug("Received enquire link pdu", "pdu", data.ENQUIRE_LINK.String())
 		s.pushPdu(p.GetResponse())
 		return false
 	case *pdu.EnquireLinkResp:
-		s.term.window.Take(p.GetSequenceNumber())
+		if request := s.take(p.GetSequenceNumber()); request != nil {
+			atomic.StoreInt64(&s.linkRtt, time.Now().UnixNano()-request.SubmitAt)
+		}
 		return false
 	case *pdu.Unbind:
-		s.info("Received unbind pdu")
+		s.info("Received unbind pdu", "pdu", data.UNBIND.String())
+		// 由 loopWrite 发送 unbind_resp 后关闭连接
 		s.pushPdu(p.GetResponse())
-		s.close(CloseByPdu, "received unbind pdu")
 		return true
 	case *pdu.UnbindResp:
-		s.info("Received unbind resp pdu")
-		s.close(CloseByPdu, "received unbind response pdu")
+		s.info("Received unbind resp pdu", "pdu", data.UNBIND_RESP.String())
+		if atomic.LoadInt32(&s.drained) == 1 {
+			s.close(CloseByShutdown, "")
+		} else {
+			s.close(CloseByPdu, "received unbind response pdu")
+		}
 		return true
 	case *pdu.BindRequest:
+		s.warn("Received bind pdu when bound", "pdu", p.GetHeader().CommandID.String())
+		s.pushPdu(rejectPdu(p, data.ESME_RALYBND))
 		return false
 	case *pdu.AlertNotification:
 		s.onReceive(p)
 		return false
+	case *pdu.DeliverSM:
+		if s.reassemble(p.(*pdu.DeliverSM)) {
+			return false
+		}
 	case *pdu.GenericNack, *pdu.Outbind:
-		s.info("Received generic nack or out bind pdu")
+		s.info("Received generic nack or out bind pdu", "pdu", p.GetHeader().CommandID.String())
 		s.close(CloseByPdu, "received unexpected pdu")
 		return true
 	}
@@ -276,20 +382,69 @@ func (s *Session) read() bool {
 			s.pushPdu(rp)
 		}
 	} else {
-		tr := s.term.window.Take(p.GetSequenceNumber())
+		tr := s.take(p.GetSequenceNumber())
 		if tr != nil {
-			s.onRespond(NewResponse(tr, p, nil))
+			response := NewResponse(tr, p, nil)
+			if s.conf.Metrics != nil {
+				s.conf.Metrics.Responded(s, response, time.Duration(time.Now().UnixNano()-tr.SubmitAt))
+			}
+			s.feedback(response)
+			if !s.retry(response) {
+				s.onRespond(response)
+			}
 		}
 	}
 
 	return false
 }
 
-func (s *Session) allowRead(_ pdu.PDU) bool {
-	// todo 根据会话角色和绑定类型限制可以接收哪些类型的 pdu
+func (s *Session) reassemble(p *pdu.DeliverSM) bool {
+	if s.reasm == nil {
+		return false
+	}
+
+	message, ok := s.reasm.Add(p)
+	if !ok {
+		return false
+	}
+
+	// 每个分片都需要响应
+	s.pushPdu(p.GetResponse())
+	if message != nil {
+		s.onConcat(message)
+	}
+
+	// 分片已响应，被淘汰的不完整消息也需要交给应用
+	s.onPartial(s.reasm.Expire())
+
 	return true
 }
 
+func (s *Session) allowRead(p pdu.PDU) bool {
+	// bind 和 outbind 由 read() 处理
+	switch p.(type) {
+	case *pdu.BindRequest, *pdu.Outbind:
+		return true
+	}
+
+	if AllowPdu(s.conn.Role(), s.BindType(), false, p.GetHeader().CommandID) {
+		return true
+	}
+
+	// 响应不能再被响应，直接丢弃
+	id := p.GetHeader().CommandID
+	if isResponse(id) {
+		s.warn("Dropped not allowed response pdu", "pdu", id.String())
+		return false
+	}
+
+	// 拒绝当前绑定类型不允许接收的请求
+	s.warn("Received not allowed pdu", "pdu", id.String())
+	s.pushPdu(rejectPdu(p, data.ESME_RINVBNDSTS))
+
+	return false
+}
+
 func (s *Session) loopWrite() {
 	defer func() {
 		drain := true // 把 pduCh 排空，防止 loopRead 阻塞
@@ -321,7 +476,7 @@ func (s *Session) write(p pdu.PDU) bool {
 	}
 
 	if n, err := s.conn.Write(p); err != nil {
-		s.warn("Write failed, error: %v", err)
+		s.warn("Write failed", "pdu", p.GetHeader().CommandID.String(), "error", err)
 		if n > 0 {
 			s.close(CloseByError, err.Error())
 			return true
@@ -330,6 +485,21 @@ func (s *Session) write(p pdu.PDU) bool {
 			s.close(CloseByError, err.Error())
 			return true
 		}
+		// 收到 unbind 后 loopRead 已退出，unbind_resp 发送失败时也需要关闭连接
+		if _, ok := p.(*pdu.UnbindResp); ok {
+			s.close(CloseByError, err.Error())
+			return true
+		}
+		return false
+	}
+	atomic.StoreInt64(&s.term.writeAt, time.Now().UnixNano())
+	if s.conf.Metrics != nil {
+		s.conf.Metrics.PduSent(s, p)
+	}
+
+	if _, ok := p.(*pdu.UnbindResp); ok {
+		s.close(CloseByPdu, "received unbind pdu")
+		return true
 	}
 
 	return false
@@ -343,24 +513,40 @@ func (s *Session) loopSend() {
 			case <-s.term.ctx.Done():
 				return
 			case r := <-s.term.reqCh:
-				if s.send(r) {
+				if s.sendQueued(r) {
 					return
 				}
 			}
 		}
 	} else {
-		tk := time.NewTicker(s.conf.EnquireLink)
-		defer tk.Stop()
+		tm := time.NewTimer(s.conf.EnquireLink)
+		defer tm.Stop()
 		for {
 			select {
 			case <-s.term.ctx.Done():
 				return
-			case <-tk.C:
+			case <-tm.C:
+				// 有流量时推迟心跳
+				if s.conf.EnquireIdle {
+					if idle := s.idleFor(); idle < s.conf.EnquireLink {
+						tm.Reset(s.conf.EnquireLink - idle)
+						continue
+					}
+				}
+				tm.Reset(s.conf.EnquireLink)
+				if s.linkDead() {
+					s.close(CloseByHeartbeat, "enquire link unanswered")
+					return
+				}
+				// 窗口已满时链接正忙，跳过本次心跳，防止心跳因无法放入窗口而被计为未响应
+				if s.term.window.Full() {
+					continue
+				}
 				if s.send(s.newRequest(SubmitBySys, pdu.NewEnquireLink(), nil)) {
 					return
 				}
 			case r := <-s.term.reqCh:
-				if s.send(r) {
+				if s.sendQueued(r) {
 					return
 				}
 			}
@@ -368,16 +554,46 @@ func (s *Session) loopSend() {
 	}
 }
 
+// sendQueued 发送从 reqCh 取出的请求，请求放入窗口或通知结果后才减少 queued，使 idle 不会遗漏它
+func (s *Session) sendQueued(request *Request) bool {
+	if request == nil {
+		return true
+	}
+	defer atomic.AddInt32(&s.queued, -1)
+	return s.send(request)
+}
+
+func (s *Session) idleFor() time.Duration {
+	last := max(atomic.LoadInt64(&s.term.readAt), atomic.LoadInt64(&s.term.writeAt))
+	return time.Since(time.Unix(0, last))
+}
+
+func (s *Session) linkDead() bool {
+	if s.conf.EnquireMiss <= 0 {
+		return false
+	}
+	misses := atomic.LoadInt32(&s.term.misses)
+	if misses < int32(s.conf.EnquireMiss) {
+		return false
+	}
+	s.warn("Enquire link unanswered", "count", misses)
+	return true
+}
+
 func (s *Session) send(request *Request) bool {
 	// 若 request == nil，说明通道已关闭
 	if request == nil {
 		return true
 	}
 
+	// 若请求已被取消，则不再发送
+	if request.canceled() {
+		return false
+	}
+
 	// 若链接已关闭，则尽快结束此协程
 	if s.connClosed() {
-		s.onRespond(NewResponse(request, nil, ErrConnectionClosed))
-		return true
+		return s.unsend(request)
 	}
 
 	// 判断 pdu 是否可以发送
@@ -386,29 +602,48 @@ func (s *Session) send(request *Request) bool {
 		return false
 	}
 
-	// 可以响应的 pdu 需要添加到窗口中
-	request.SubmitAt = time.Now().Unix()
+	// 限制用户提交的速率，心跳不受限制
+	if request.submitter == SubmitByUsr && s.conf.Limiter != nil {
+		if err := s.conf.Limiter.Wait(s.term.ctx); err != nil {
+			return s.unsend(request)
+		}
+		if request.canceled() { // 等待期间可能被取消
+			return false
+		}
+	}
+
+	// 可以响应的 pdu 需要分配序列号并添加到窗口中
+	request.SubmitAt = time.Now().UnixNano()
 	if request.Pdu.CanResponse() {
 		// 若窗口已满，则等待窗口可用
 		if s.conf.WindowBlock != 0 {
-			for s.term.window.Full() {
-				if s.connClosed() { // 防止此协程不能退出
-					s.onRespond(NewResponse(request, nil, ErrConnectionClosed))
-					return true
-				}
-				if s.conf.WindowBlock > 0 {
-					time.Sleep(s.conf.WindowBlock)
-				} else {
-					runtime.Gosched()
-				}
+			if err := s.acquire(); err != nil { // 链接关闭时退出等待，防止此协程不能退出
+				return s.unsend(request)
+			}
+			if request.canceled() { // 等待期间可能被取消
+				return false
 			}
 		}
 		// 将请求添加至窗口
+		request.Pdu.SetSequenceNumber(s.nextSequence())
+		atomic.AddInt32(&s.flight, 1) // 先于 Put 增加，防止响应先到达时数量为负数
 		if err := s.term.window.Put(request); err != nil {
-			s.warn("Put request to window failed, error: %v", err)
+			atomic.AddInt32(&s.flight, -1)
+			s.warn("Put request to window failed", "pdu", request.Pdu.GetHeader().CommandID.String(), "error", err)
 			s.onRespond(NewResponse(request, nil, err))
 			return false
 		}
+		// 心跳放入窗口后才计为未响应
+		if _, ok := request.Pdu.(*pdu.EnquireLink); ok && request.submitter == SubmitBySys {
+			atomic.AddInt32(&s.term.misses, 1)
+		}
+		// 超时时间早于 loopClear 的下一次检查时间时，唤醒 loopClear
+		if request.ExpireAt(s.conf.WindowWait) < atomic.LoadInt64(&s.term.expAt) {
+			select {
+			case s.term.expCh <- struct{}{}:
+			default:
+			}
+		}
 	}
 
 	// 发送 pdu
@@ -418,38 +653,253 @@ func (s *Session) send(request *Request) bool {
 	return false
 }
 
+// unsend 记录链接关闭时未发送的请求，由 close 保留或通知失败，并返回 true 结束 loopSend
+func (s *Session) unsend(request *Request) bool {
+	request.SubmitAt = 0 // 未发送，恢复失败时以 ErrConnectionClosed 通知
+	s.term.unsent = request
+	return true
+}
+
+// acquire 等待窗口可用，窗口未实现 WindowAcquirer 时按 WindowBlock 轮询
+func (s *Session) acquire() error {
+	if wa, ok := s.term.window.(WindowAcquirer); ok {
+		return wa.Acquire(s.term.ctx)
+	}
+	for s.term.window.Full() {
+		if err := s.term.ctx.Err(); err != nil {
+			return err
+		}
+		if s.conf.WindowBlock > 0 {
+			time.Sleep(s.conf.WindowBlock)
+		} else {
+			runtime.Gosched()
+		}
+	}
+	return nil
+}
+
 func (s *Session) allowSend(p pdu.PDU) bool {
+	// 绑定、解绑等 pdu 由会话自身管理
 	switch p.(type) {
-	case *pdu.BindRequest, *pdu.Unbind, *pdu.Outbind, *pdu.GenericNack, *pdu.AlertNotification:
+	case *pdu.BindRequest, *pdu.Unbind, *pdu.Outbind, *pdu.GenericNack:
 		return false
 	}
-	// todo 根据会话角色和绑定类型限制可以提交哪些类型的 pdu
-	return true
+	return AllowPdu(s.conn.Role(), s.BindType(), true, p.GetHeader().CommandID)
 }
 
 func (s *Session) loopClear() {
 	defer s.term.swg.Done()
 	tk := time.NewTicker(s.conf.WindowScan)
 	defer tk.Stop()
+
+	// 窗口超时定时器，在窗口中最早的超时时间触发
+	tm := time.NewTimer(s.conf.WindowScan)
+	defer tm.Stop()
+	s.resetClear(tm)
+
+	// 服务端不活跃检测定时器，未开启时为 nil
+	var (
+		it *time.Timer
+		ic <-chan time.Time
+	)
+	if s.conf.IdleTimeout > 0 && s.conn.Role() == RoleServer {
+		it = time.NewTimer(s.conf.IdleTimeout)
+		defer it.Stop()
+		ic = it.C
+	}
+
 	for {
 		select {
 		case <-s.term.ctx.Done():
 			return
-		case <-tk.C:
+		case <-ic:
+			silent := time.Since(time.Unix(0, atomic.LoadInt64(&s.term.readAt)))
+			if silent >= s.conf.IdleTimeout {
+				s.info("Inactive", "silent", silent.String())
+				s.close(CloseByIdle, "no pdu received")
+				return
+			}
+			it.Reset(s.conf.IdleTimeout - silent)
+		case <-s.term.expCh:
+			s.resetClear(tm)
+		case <-tm.C:
+			// 已从窗口取出的请求必须全部通知，即使链接已关闭，否则它们不会再被处理
 			requests := s.term.window.TakeTimeout()
+			atomic.AddInt32(&s.flight, -int32(len(requests)))
 			for _, request := range requests {
-				if s.connClosed() {
-					return
+				response := NewResponse(request, nil, ErrResponseTimeout)
+				if s.conf.Metrics != nil {
+					s.conf.Metrics.TimedOut(s, request)
 				}
-				s.onRespond(NewResponse(request, nil, ErrResponseTimeout))
+				s.feedback(response)
+				s.onRespond(response)
+			}
+			if len(requests) > 0 {
+				s.debug("Handled timeout requests", "count", len(requests))
+			}
+			s.resetClear(tm)
+		case <-tk.C:
+			if s.reasm != nil {
+				s.onPartial(s.reasm.Expire())
 			}
-			s.debug("Handled timeout requests, count: %d", len(requests))
 		}
 	}
 }
 
+func (s *Session) nextSequence() int32 {
+	// 序列号取值范围为 1 ~ 0x7FFFFFFF，溢出后从 1 开始
+	for {
+		curr := atomic.LoadInt32(&s.seqNum)
+		next := curr + 1
+		if next <= 0 {
+			next = 1
+		}
+		if atomic.CompareAndSwapInt32(&s.seqNum, curr, next) {
+			return next
+		}
+	}
+}
+
+func (s *Session) resetClear(tm *time.Timer) {
+	// 最长间隔 WindowScan 检查一次窗口
+	wait := s.conf.WindowScan
+	if wd, ok := s.term.window.(WindowDeadline); ok {
+		if next := wd.NextTimeout(); !next.IsZero() {
+			wait = min(max(time.Until(next), 0), wait)
+		}
+	}
+	atomic.StoreInt64(&s.term.expAt, time.Now().Add(wait).UnixNano())
+	tm.Reset(wait)
+}
+
+func (s *Session) retry(response *Response) bool {
+	request := response.Request
+	if s.conf.RetryPolicy == nil || request.submitter != SubmitByUsr {
+		return false
+	}
+
+	status := response.Pdu.GetHeader().CommandStatus
+	if status == data.ESME_ROK {
+		return false
+	}
+
+	wait, ok := s.conf.RetryPolicy.Retry(request.retries+1, status)
+	if !ok {
+		return false
+	}
+	request.retries++
+	s.debug("Retry request", "pdu", request.Pdu.GetHeader().CommandID.String(), "attempt", request.retries, "status", status.String(), "wait", wait.String())
+
+	// 链接关闭时，以最近一次的响应作为最终结果
+	s.requeue(request, wait, response)
+
+	return true
+}
+
+// requeue 等待 wait 后将请求重新放入发送通道，若期间链接关闭，则以 fallback 作为最终结果
+func (s *Session) requeue(request *Request, wait time.Duration, fallback *Response) {
+	// 与 pushRequest 相同，防止向已关闭的通道写入，并让 Shutdown 等待请求完成
+	atomic.AddInt32(&s.pending, 1)
+	term := s.term
+	go func() {
+		defer atomic.AddInt32(&s.pending, -1)
+
+		if wait > 0 {
+			tm := time.NewTimer(wait)
+			defer tm.Stop()
+			select {
+			case <-term.ctx.Done():
+				s.onRespond(fallback)
+				return
+			case <-tm.C:
+			}
+		}
+
+		atomic.AddInt32(&s.queued, 1)
+		select {
+		case term.reqCh <- request:
+		case <-term.ctx.Done():
+			atomic.AddInt32(&s.queued, -1)
+			s.onRespond(fallback)
+		}
+	}()
+}
+
+func (s *Session) recoverHeld() {
+	if len(s.held) == 0 {
+		return
+	}
+
+	// 按创建时间顺序重新发送，超过 RecoverAge 的请求不再发送
+	held := make([]*Request, 0, len(s.held))
+	for _, request := range s.held {
+		if time.Since(request.createAt) > s.conf.RecoverAge {
+			s.onRespond(NewResponse(request, nil, heldError(request)))
+		} else {
+			held = append(held, request)
+		}
+	}
+	slices.SortFunc(held, func(a, b *Request) int {
+		return a.createAt.Compare(b.createAt)
+	})
+	s.info("Recovering requests", "count", len(held), "expired", len(s.held)-len(held))
+	s.held = nil
+
+	// 与 pushRequest 相同，防止向已关闭的通道写入
+	atomic.AddInt32(&s.pending, 1)
+	term := s.term
+	go func() {
+		defer atomic.AddInt32(&s.pending, -1)
+		for i, request := range held {
+			atomic.AddInt32(&s.queued, 1)
+			select {
+			case term.reqCh <- request:
+			case <-term.ctx.Done():
+				atomic.AddInt32(&s.queued, -1)
+				for _, request = range held[i:] {
+					s.onRespond(NewResponse(request, nil, heldError(request)))
+				}
+				return
+			}
+		}
+	}()
+}
+
+// hold 链接断开时保留用户的请求，重连后重新发送，不保留时以 err 通知失败
+func (s *Session) hold(request *Request, holding bool, err error) {
+	if holding && request.submitter == SubmitByUsr {
+		s.held = append(s.held, request)
+		return
+	}
+	s.onRespond(NewResponse(request, nil, err))
+}
+
+func (s *Session) failHeld() {
+	for _, request := range s.held {
+		s.onRespond(NewResponse(request, nil, heldError(request)))
+	}
+	s.held = nil
+}
+
+// heldError 已发送的请求结果未知，未发送的请求确定未送达
+func heldError(request *Request) error {
+	if request.SubmitAt > 0 {
+		return ErrConnectionLost
+	}
+	return ErrConnectionClosed
+}
+
+func (s *Session) feedback(response *Response) {
+	if fb, ok := s.term.window.(WindowFeedback); ok {
+		fb.Feedback(response)
+	}
+}
+
 func (s *Session) onDialed() {
 	s.store.AddSession(s)
+	if s.conf.Metrics != nil {
+		s.conf.Metrics.Bound(s)
+	}
 	if s.conf.OnDialed != nil {
 		s.conf.OnDialed(s)
 	}
@@ -457,11 +907,23 @@ func (s *Session) onDialed() {
 
 func (s *Session) onClosed(reason string, desc string) {
 	s.store.DeleteSession(s.id)
+	if s.conf.Metrics != nil {
+		s.conf.Metrics.SessionClosed(s)
+	}
 	if s.conf.OnClosed != nil {
 		s.conf.OnClosed(s, reason, desc)
 	}
 }
 
+func (s *Session) onRedial(attempt int, err error) {
+	if s.conf.Metrics != nil {
+		s.conf.Metrics.Redialed(s, attempt, err)
+	}
+	if s.conf.OnRedial != nil {
+		s.conf.OnRedial(s, attempt, err)
+	}
+}
+
 func (s *Session) onReceive(p pdu.PDU) pdu.PDU {
 	if s.conf.OnReceive != nil {
 		return s.conf.OnReceive(s, p)
@@ -476,17 +938,63 @@ func (s *Session) onRequest(request *Request) {
 }
 
 func (s *Session) onRespond(response *Response) {
+	if response.Request.pending != nil {
+		response.Request.pending.resolve(response)
+		return
+	}
 	if s.conf.OnRespond != nil && response.Request.submitter == SubmitByUsr {
 		s.conf.OnRespond(s, response)
 	}
 }
 
+func (s *Session) onConcat(message *ConcatMessage) {
+	if s.conf.OnConcat != nil {
+		s.conf.OnConcat(s, message)
+	}
+}
+
+func (s *Session) onPartial(messages []*ConcatMessage) {
+	if len(messages) == 0 {
+		return
+	}
+	s.warn("Delivered incomplete concatenated messages", "count", len(messages))
+	for _, message := range messages {
+		s.onConcat(message)
+	}
+}
+
 func (s *Session) pushPdu(p pdu.PDU) {
 	s.term.pduCh <- p
 }
 
-func (s *Session) pushRequest(submitter int8, p pdu.PDU, data any) {
-	s.term.reqCh <- s.newRequest(submitter, p, data)
+func (s *Session) pushRequest(ctx context.Context, request *Request) error {
+	if s.connClosed() {
+		return ErrConnectionClosed
+	}
+
+	if atomic.LoadInt32(&s.drained) == 1 {
+		return ErrSessionDraining
+	}
+
+	if !s.allowSend(request.Pdu) {
+		return ErrNotAllowed
+	}
+
+	atomic.AddInt32(&s.pending, 1)
+	defer atomic.AddInt32(&s.pending, -1)
+
+	if s.connClosed() {
+		return ErrConnectionClosed
+	}
+
+	atomic.AddInt32(&s.queued, 1)
+	select {
+	case s.term.reqCh <- request:
+		return nil
+	case <-ctx.Done():
+		atomic.AddInt32(&s.queued, -1)
+		return ctx.Err()
+	}
 }
 
 func (s *Session) newRequest(submitter int8, p pdu.PDU, data any) *Request {
@@ -497,6 +1005,7 @@ func (s *Session) newRequest(submitter int8, p pdu.PDU, data any) *Request {
 		SubmitAt:  0,
 		TraceData: data,
 		submitter: submitter,
+		createAt:  time.Now(),
 	}
 }
 
@@ -508,26 +1017,26 @@ func (s *Session) connClosed() bool {
 	return atomic.LoadInt32(&s.status) == ConnectionClosed
 }
 
-func (s *Session) debug(m string, a ...any) {
-	if s.slog != nil {
-		s.slog.Debug(s.formatLog(m, a...))
+func (s *Session) debug(m string, fields ...any) {
+	if s.log != nil {
+		s.log.Debug(m, s.logFields(fields)...)
 	}
 }
 
-func (s *Session) info(m string, a ...any) {
-	if s.slog != nil {
-		s.slog.Info(s.formatLog(m, a...))
+func (s *Session) info(m string, fields ...any) {
+	if s.log != nil {
+		s.log.Info(m, s.logFields(fields)...)
 	}
 }
 
-func (s *Session) warn(m string, a ...any) {
-	if s.slog != nil {
-		s.slog.Warn(s.formatLog(m, a...))
+func (s *Session) warn(m string, fields ...any) {
+	if s.log != nil {
+		s.log.Warn(m, s.logFields(fields)...)
 	}
 }
 
-func (s *Session) formatLog(m string, a ...any) string {
-	return fmt.Sprintf("[Session@%s:%s] ", s.Id(), s.SystemId()) + fmt.Sprintf(m, a...)
+func (s *Session) logFields(fields []any) []any {
+	return append([]any{"session_id", s.Id(), "system_id", s.SystemId(), "peer_addr", s.PeerAddr()}, fields...)
 }
 
 // Id get this session ID
@@ -566,11 +1075,32 @@ func (s *Session) DialAt() time.Time {
 	return s.term.dialAt
 }
 
-// GetWindow get the window of current SMPP connection
+// LinkRtt get the round-trip time of the latest answered enquire link
+func (s *Session) LinkRtt() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.linkRtt))
+}
+
+// GetWindow get the window of current SMPP connection, it must not be used by other goroutines
+// than the callbacks of the session, use InFlight to get the number of requests in the window
 func (s *Session) GetWindow() Window {
 	return s.term.window
 }
 
+// InFlight get the number of requests waiting for response in the window, it is safe for
+// concurrent use
+func (s *Session) InFlight() int {
+	return int(atomic.LoadInt32(&s.flight))
+}
+
+// take 从窗口中取出请求，并更新窗口中的请求数量
+func (s *Session) take(sequence int32) *Request {
+	request := s.term.window.Take(sequence)
+	if request != nil {
+		atomic.AddInt32(&s.flight, -1)
+	}
+	return request
+}
+
 // GetContext get the session context
 func (s *Session) GetContext() any {
 	return s.conf.Context
@@ -582,22 +1112,62 @@ func (s *Session) SetContext(ctx any) {
 }
 
 // Write send a PDU to peer terminal, the data is user-custom data for trace the PDU request, you
-// can fetch this data exactly as it is by Response.TraceData() when you receive the PDU response
+// can fetch this data exactly as it is by Response.TraceData() when you receive the PDU response.
+// The sequence number of a responsive PDU is reassigned by the session before sending, so do not
+// write the same PDU concurrently
 func (s *Session) Write(p pdu.PDU, data any) error {
-	if s.connClosed() {
-		return ErrConnectionClosed
+	return s.pushRequest(context.Background(), s.newRequest(SubmitByUsr, p, data))
+}
+
+// WriteTimeout send a PDU to peer terminal like Write, but the response of the PDU will be timed
+// out after timeout instead of WindowWait
+func (s *Session) WriteTimeout(p pdu.PDU, data any, timeout time.Duration) error {
+	request := s.newRequest(SubmitByUsr, p, data)
+	request.Timeout = timeout
+	return s.pushRequest(context.Background(), request)
+}
+
+// Submit send a PDU to peer terminal like Write, but returns a Pending handle of the request
+// instead of passing the response to OnRespond. The handle is resolved by the response PDU,
+// ErrResponseTimeout, ErrConnectionClosed, ErrConnectionLost or Pending.Cancel()
+func (s *Session) Submit(p pdu.PDU) (*Pending, error) {
+	return s.submit(context.Background(), p)
+}
+
+func (s *Session) submit(ctx context.Context, p pdu.PDU) (*Pending, error) {
+	if !p.CanResponse() {
+		return nil, ErrNotResponsive
 	}
 
-	atomic.AddInt32(&s.pending, 1)
-	var err error
-	if s.connClosed() {
-		err = ErrConnectionClosed
-	} else {
-		s.pushRequest(SubmitByUsr, p, data)
+	request := s.newRequest(SubmitByUsr, p, nil)
+	request.pending = newPending(request)
+	if deadline, ok := ctx.Deadline(); ok {
+		request.Timeout = max(time.Until(deadline), time.Millisecond)
+	}
+	if err := s.pushRequest(ctx, request); err != nil {
+		return nil, err
 	}
-	atomic.AddInt32(&s.pending, -1)
 
-	return err
+	return request.pending, nil
+}
+
+// Call send a PDU to peer terminal and wait for its response PDU. It returns ErrResponseTimeout
+// if the response is not received within WindowWait or the deadline of ctx, ErrConnectionClosed or
+// ErrConnectionLost if the connection is closed before that, or the error of ctx if ctx is done
+// first. The response of Call will not be passed to OnRespond
+func (s *Session) Call(ctx context.Context, p pdu.PDU) (pdu.PDU, error) {
+	pending, err := s.submit(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := pending.Wait(ctx)
+	if err != nil {
+		pending.Cancel()
+		return nil, err
+	}
+
+	return response.Pdu, response.Error
 }
 
 // Close close this session completely, this session will not reconnect after Close()
@@ -606,6 +1176,82 @@ func (s *Session) Close() {
 	s.close(CloseByExplicit, "")
 }
 
+// Shutdown close this session gracefully. It stops accepting new requests, waits until the
+// queued and in-flight requests are responded or ctx is done, then sends unbind and waits for
+// unbind_resp before closing the connection. This session will not reconnect after Shutdown()
+func (s *Session) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closed, 1)
+	atomic.StoreInt32(&s.drained, 1)
+
+	// 等待通道和窗口中的请求处理完成
+	tk := time.NewTicker(10 * time.Millisecond)
+	defer tk.Stop()
+	for !s.connClosed() && !s.idle() {
+		select {
+		case <-ctx.Done():
+			s.close(CloseByShutdown, ctx.Err().Error())
+			return ctx.Err()
+		case <-tk.C:
+		}
+	}
+	if s.connClosed() {
+		return nil
+	}
+	s.debug("Drained")
+
+	// 发送解绑请求，收到 unbind_resp 后关闭连接
+	if err := s.pushUnbind(ctx); err != nil {
+		if err == ErrConnectionClosed {
+			return nil
+		}
+		s.close(CloseByShutdown, err.Error())
+		return err
+	}
+	tm := time.NewTimer(s.conf.WindowWait)
+	defer tm.Stop()
+	for !s.connClosed() {
+		select {
+		case <-ctx.Done():
+			s.close(CloseByShutdown, ctx.Err().Error())
+			return ctx.Err()
+		case <-tm.C:
+			s.close(CloseByShutdown, "unbind response timeout")
+			return ErrResponseTimeout
+		case <-tk.C:
+		}
+	}
+
+	return nil
+}
+
+func (s *Session) idle() bool {
+	// queued 包含通道中的请求，以及 send 正在处理但还未放入窗口的请求
+	if atomic.LoadInt32(&s.pending) > 0 || atomic.LoadInt32(&s.queued) > 0 {
+		return false
+	}
+	return atomic.LoadInt32(&s.flight) == 0
+}
+
+func (s *Session) pushUnbind(ctx context.Context) error {
+	// 与 pushRequest 相同，防止向已关闭的通道写入
+	atomic.AddInt32(&s.pending, 1)
+	defer atomic.AddInt32(&s.pending, -1)
+
+	if s.connClosed() {
+		return ErrConnectionClosed
+	}
+
+	// pduCh 暂时已满时等待，不能不发送 unbind 就关闭链接
+	select {
+	case s.term.pduCh <- pdu.NewUnbind():
+		return nil
+	case <-s.term.ctx.Done():
+		return ErrConnectionClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 // Status get the session status
 // SessionActive:  this session's connection is active
 // SessionClosed:  this session has been closed completely
@@ -627,7 +1273,7 @@ func (s *Session) IsActive() bool {
 
 // Closed has the session been completely closed
 func (s *Session) Closed() bool {
-	c1 := atomic.LoadInt32(&s.closed) == 1          // 显示关闭会话
-	c2 := s.conf.AttemptDial == 0 && s.connClosed() // 或连接已关闭并且没有开启重连
+	c1 := atomic.LoadInt32(&s.closed) == 1             // 显示关闭会话
+	c2 := s.conf.RedialPolicy == nil && s.connClosed() // 或连接已关闭并且没有开启重连
 	return c1 || c2
 }
@@ -0,0 +1,140 @@
+package smpp
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+// pipeSessions 通过内存管道建立客户端会话，服务端会话使用 server 配置
+func pipeSessions(t *testing.T, client SessionConfig, server SessionConfig) *Session {
+	cc, sc := NewPipeConnections(
+		ClientConnectionConfig{SystemId: "user1", Password: "user1", BindType: pdu.Transceiver},
+		ServerConnectionConfig{Authenticate: pipeAuthenticate},
+	)
+	go func() {
+		_, _ = NewSession(sc, server)
+	}()
+	sess, err := NewSession(cc, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sess.Close)
+	return sess
+}
+
+func TestEnquireLinkSaturatedWindow(t *testing.T) {
+	closed := make(chan string, 1)
+	sess := pipeSessions(t, SessionConfig{
+		WindowSize:  4,
+		EnquireLink: 100 * time.Millisecond,
+		EnquireMiss: 3,
+		OnRespond:   func(*Session, *Response) {},
+		OnClosed: func(_ *Session, reason string, _ string) {
+			closed <- reason
+		},
+	}, SessionConfig{
+		OnReceive: func(_ *Session, p pdu.PDU) pdu.PDU {
+			time.Sleep(20 * time.Millisecond)
+			return p.GetResponse()
+		},
+	})
+
+	// 持续提交，使窗口一直处于已满状态，链接繁忙但健康
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if err := sess.Write(pdu.NewSubmitSM(), nil); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case reason := <-closed:
+			t.Fatalf("session is closed by %s", reason)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	if !sess.IsActive() {
+		t.Fatal("session is not active")
+	}
+}
+
+func TestShutdownWaitsLimitedRequests(t *testing.T) {
+	responses := make(chan *Response, 2)
+	sess := pipeSessions(t, SessionConfig{
+		Limiter: NewLimiter(1, 1),
+		OnRespond: func(_ *Session, response *Response) {
+			responses <- response
+		},
+	}, pipeServerConfig(pdu.NewDeliverSM()))
+
+	for i := 0; i < 2; i++ {
+		if err := sess.Write(pdu.NewSubmitSM(), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := sess.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// 第二个请求在限速器中等待，Shutdown 需要等待它完成
+	for i := 0; i < 2; i++ {
+		response := <-responses
+		if response.Error != nil {
+			t.Errorf("request %v: %v", response.TraceData(), response.Error)
+		}
+	}
+}
+
+func TestCloseReportsQueuedRequests(t *testing.T) {
+	for _, recoverAge := range []time.Duration{0, time.Minute} {
+		peer := make(chan *Session, 1)
+		responses := make(chan *Response, 3)
+		client := SessionConfig{
+			WindowSize:  1,
+			WindowBlock: time.Millisecond,
+			RecoverAge:  recoverAge,
+			OnRespond: func(_ *Session, response *Response) {
+				responses <- response
+			},
+		}
+		if recoverAge > 0 {
+			client.RedialPolicy = &Backoff{Min: time.Millisecond, Attempts: 1}
+		}
+		sess := pipeSessions(t, client, SessionConfig{
+			OnDialed: func(sess *Session) {
+				peer <- sess
+			},
+			OnReceive: func(*Session, pdu.PDU) pdu.PDU {
+				return nil // 不响应，请求一直留在窗口中
+			},
+		})
+
+		// 第一个请求在窗口中，第二个在 send 中等待窗口，第三个在通道中
+		for i := 1; i <= 3; i++ {
+			if err := sess.Write(pdu.NewSubmitSM(), i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		(<-peer).Close()
+
+		for i := 1; i <= 3; i++ {
+			select {
+			case response := <-responses:
+				// 只有第一个请求已发送
+				want := ErrConnectionClosed
+				if response.TraceData() == 1 {
+					want = ErrConnectionLost
+				}
+				if response.Error != want {
+					t.Errorf("recover age %v: request %v: %v, want %v", recoverAge, response.TraceData(), response.Error, want)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatalf("recover age %v: %d requests are not responded", recoverAge, 4-i)
+			}
+		}
+	}
+}
@@ -15,10 +15,21 @@ import (
 
 // ======================== Log ========================
 
-var _slog *logrus.Logger
+var _log Logger
 
+// SetLog set the default logger by a logrus logger, nil disables logging
 func SetLog(slog *logrus.Logger) {
-	_slog = slog
+	if slog == nil {
+		_log = nil
+		return
+	}
+	_log = NewLogrusLogger(slog)
+}
+
+// SetLogger set the default logger of sessions, servers and listeners, nil disables logging.
+// SessionConfig.Logger overrides it for a session
+func SetLogger(logger Logger) {
+	_log = logger
 }
 
 // ======================== Session ========================
@@ -132,6 +143,13 @@ func BinaryMessage(s []byte) pdu.ShortMessage {
 
 // ======================== Other ========================
 
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
 func PrintPdu(tag string, systemId string, p pdu.PDU) {
 	if p != nil {
 		bs, _ := json.MarshalIndent(p, "", "  ")
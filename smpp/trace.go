@@ -0,0 +1,497 @@
+package smpp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/linxGnu/gosmpp/data"
+	"github.com/linxGnu/gosmpp/errors"
+	"github.com/linxGnu/gosmpp/pdu"
+)
+
+const (
+	TraceIn  = "in"  // the PDU is read from peer terminal
+	TraceOut = "out" // the PDU is written to peer terminal
+)
+
+// Trace the record of a PDU exchanged on the wire
+type Trace struct {
+	Time      time.Time
+	Direction string // TraceIn or TraceOut
+	SelfAddr  string
+	PeerAddr  string
+	Command   data.CommandIDType
+	Status    data.CommandStatusType
+	Sequence  int32
+	Raw       []byte       // the raw bytes of the PDU, password and short_message are masked unless plain
+	Fields    []TraceField // the decoded fields of the PDU body
+	Error     error        // the error of parsing the raw bytes
+}
+
+type TraceField struct {
+	Name  string
+	Value string
+}
+
+// TraceSink receive the traces of connections, set it as ClientConnectionConfig.Trace or
+// ServerConnectionConfig.Trace. WriteTrace is called in the reading and writing goroutines
+type TraceSink interface {
+	WriteTrace(*Trace)
+}
+
+// String format the trace with a hex dump of the raw bytes
+func (t *Trace) String() string {
+	var b strings.Builder
+
+	_, _ = fmt.Fprintf(&b, "%s %-3s %s -> %s %s %s seq=%d len=%d\n",
+		t.Time.Format(time.RFC3339Nano), strings.ToUpper(t.Direction), t.SelfAddr, t.PeerAddr,
+		t.Command, t.Status, t.Sequence, len(t.Raw))
+	for _, field := range t.Fields {
+		_, _ = fmt.Fprintf(&b, "  %s: %s\n", field.Name, field.Value)
+	}
+	if t.Error != nil {
+		_, _ = fmt.Fprintf(&b, "  error: %v\n", t.Error)
+	}
+	for _, line := range strings.Split(strings.TrimRight(hex.Dump(t.Raw), "\n"), "\n") {
+		b.WriteString("  ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+func newTrace(direction string, conn net.Conn, raw []byte, p pdu.PDU, plain bool) *Trace {
+	t := &Trace{
+		Time:      time.Now(),
+		Direction: direction,
+		Raw:       raw,
+	}
+	if conn != nil {
+		t.SelfAddr, t.PeerAddr = GetConnAddrs(conn)
+	}
+	if len(raw) >= 16 {
+		t.Command = data.CommandIDType(binary.BigEndian.Uint32(raw[4:]))
+		t.Status = data.CommandStatusType(binary.BigEndian.Uint32(raw[8:]))
+		t.Sequence = int32(binary.BigEndian.Uint32(raw[12:]))
+	}
+	if !plain {
+		t.Raw = maskRaw(raw)
+	}
+	if p != nil {
+		t.Fields = traceFields(p, raw, plain)
+	}
+	return t
+}
+
+// wireTap 记录链接上读写的原始字节，trace 和 capture 共用
+type wireTap struct {
+	trace   TraceSink
+	plain   bool
+	capture *CaptureWriter
+}
+
+func (t *wireTap) enabled() bool {
+	return t.trace != nil || t.capture != nil
+}
+
+func (t *wireTap) record(at time.Time, direction string, conn net.Conn, raw []byte, p pdu.PDU, err error) {
+	if t.capture != nil {
+		_ = t.capture.Write(&CaptureRecord{Time: at, Direction: direction, Raw: raw})
+	}
+	if t.trace != nil {
+		tr := newTrace(direction, conn, raw, p, t.plain)
+		tr.Time = at
+		tr.Error = err
+		t.trace.WriteTrace(tr)
+	}
+}
+
+// tapReadConn 与 ReadConn 相同，但先读取原始字节再解析，并记录原始字节
+func tapReadConn(conn net.Conn, timeout time.Duration, tap *wireTap) (pdu.PDU, error) {
+	if timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+			return nil, err
+		}
+	}
+
+	var head [16]byte
+	if _, err := io.ReadFull(conn, head[:]); err != nil {
+		return nil, err
+	}
+	length := int32(binary.BigEndian.Uint32(head[:]))
+	if length < 16 || length > data.MAX_PDU_LEN {
+		tap.record(time.Now(), TraceIn, conn, head[:], nil, errors.ErrInvalidPDU)
+		return nil, errors.ErrInvalidPDU
+	}
+	raw := make([]byte, length)
+	copy(raw, head[:])
+	if _, err := io.ReadFull(conn, raw[16:]); err != nil {
+		return nil, err
+	}
+
+	p, err := pdu.Parse(bytes.NewReader(raw))
+	tap.record(time.Now(), TraceIn, conn, raw, p, err)
+
+	return p, err
+}
+
+// tapWriteConn 与 WriteConn 相同，写入成功后记录原始字节
+func tapWriteConn(conn net.Conn, pd pdu.PDU, timeout time.Duration, tap *wireTap) (int, error) {
+	buf := pdu.NewBuffer(make([]byte, 0, 32))
+	pd.Marshal(buf)
+
+	if timeout > 0 {
+		if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	// 记录开始写入的时间，对端的响应可能在写入返回前已被读取
+	at := time.Now()
+	n, err := conn.Write(buf.Bytes())
+	if err == nil {
+		tap.record(at, TraceOut, conn, buf.Bytes(), pd, nil)
+	}
+
+	return n, err
+}
+
+// ======================== Decode ========================
+
+const traceMask = "***"
+
+func traceFields(p pdu.PDU, raw []byte, plain bool) []TraceField {
+	var fields []TraceField
+	add := func(name string, value any) {
+		fields = append(fields, TraceField{Name: name, Value: fmt.Sprint(value)})
+	}
+	addr := func(a pdu.Address) string {
+		return fmt.Sprintf("%d/%d/%s", a.Ton(), a.Npi(), a.Address())
+	}
+	secret := func(s string) string {
+		if plain {
+			return s
+		}
+		return traceMask
+	}
+	message := func(m *pdu.ShortMessage) string {
+		if !plain {
+			return traceMask
+		}
+		if text, err := m.GetMessage(); err == nil {
+			return fmt.Sprintf("%q", text)
+		}
+		d, _ := m.GetMessageData()
+		return hex.EncodeToString(d)
+	}
+
+	switch v := p.(type) {
+	case *pdu.BindRequest:
+		add("system_id", v.SystemID)
+		add("password", secret(v.Password))
+		add("system_type", v.SystemType)
+		add("interface_version", v.InterfaceVersion)
+		add("address_range", fmt.Sprintf("%d/%d/%s", v.AddressRange.Ton, v.AddressRange.Npi, v.AddressRange.AddressRange))
+	case *pdu.BindResp:
+		add("system_id", v.SystemID)
+	case *pdu.Outbind:
+		add("system_id", v.SystemID)
+		add("password", secret(v.Password))
+	case *pdu.SubmitSM:
+		add("service_type", v.ServiceType)
+		add("source_addr", addr(v.SourceAddr))
+		add("dest_addr", addr(v.DestAddr))
+		add("esm_class", v.EsmClass)
+		add("protocol_id", v.ProtocolID)
+		add("priority_flag", v.PriorityFlag)
+		add("registered_delivery", v.RegisteredDelivery)
+		add("data_coding", rawDataCoding(raw))
+		add("short_message", message(&v.Message))
+	case *pdu.SubmitSMResp:
+		add("message_id", v.MessageID)
+	case *pdu.DeliverSM:
+		add("service_type", v.ServiceType)
+		add("source_addr", addr(v.SourceAddr))
+		add("dest_addr", addr(v.DestAddr))
+		add("esm_class", v.EsmClass)
+		add("protocol_id", v.ProtocolID)
+		add("priority_flag", v.PriorityFlag)
+		add("registered_delivery", v.RegisteredDelivery)
+		add("data_coding", rawDataCoding(raw))
+		add("short_message", message(&v.Message))
+	case *pdu.DeliverSMResp:
+		add("message_id", v.MessageID)
+	case *pdu.DataSM:
+		add("service_type", v.ServiceType)
+		add("source_addr", addr(v.SourceAddr))
+		add("dest_addr", addr(v.DestAddr))
+		add("esm_class", v.EsmClass)
+		add("registered_delivery", v.RegisteredDelivery)
+		add("data_coding", v.DataCoding)
+	case *pdu.DataSMResp:
+		add("message_id", v.MessageID)
+	}
+
+	// 可选参数，按 tag 排序
+	for _, field := range traceOptions(p) {
+		value := hex.EncodeToString(field.Data)
+		if field.Tag == pdu.TagMessagePayload && !plain {
+			value = traceMask
+		}
+		add(fmt.Sprintf("tlv_0x%04x", uint16(field.Tag)), value)
+	}
+
+	return fields
+}
+
+func traceOptions(p pdu.PDU) []pdu.Field {
+	var opts map[pdu.Tag]pdu.Field
+	switch v := p.(type) {
+	case *pdu.SubmitSM:
+		opts = v.OptionalParameters
+	case *pdu.DeliverSM:
+		opts = v.OptionalParameters
+	case *pdu.DataSM:
+		opts = v.OptionalParameters
+	case *pdu.SubmitSMResp:
+		opts = v.OptionalParameters
+	case *pdu.DeliverSMResp:
+		opts = v.OptionalParameters
+	case *pdu.DataSMResp:
+		opts = v.OptionalParameters
+	}
+
+	fields := make([]pdu.Field, 0, len(opts))
+	for _, field := range opts {
+		fields = append(fields, field)
+	}
+	slices.SortFunc(fields, func(a, b pdu.Field) int {
+		return int(a.Tag) - int(b.Tag)
+	})
+	return fields
+}
+
+// ======================== Mask ========================
+
+// maskRaw 复制原始字节，并将 password、short_message 和 message_payload 替换为 '*'
+func maskRaw(raw []byte) []byte {
+	out := bytes.Clone(raw)
+	if len(out) < 16 {
+		return out
+	}
+
+	c := &traceCursor{b: out[16:]}
+	switch data.CommandIDType(binary.BigEndian.Uint32(out[4:])) {
+	case data.BIND_RECEIVER, data.BIND_TRANSMITTER, data.BIND_TRANSCEIVER, data.OUTBIND:
+		c.cstring() // system_id
+		c.mask(c.cstring())
+	case data.SUBMIT_SM, data.DELIVER_SM:
+		c.cstring() // service_type
+		c.skip(2)
+		c.cstring() // source_addr
+		c.skip(2)
+		c.cstring() // destination_addr
+		c.skip(3)
+		c.cstring() // schedule_delivery_time
+		c.cstring() // validity_period
+		c.skip(4)
+		c.mask(c.skip(int(c.byte())))
+		c.maskPayload()
+	case data.DATA_SM:
+		c.cstring() // service_type
+		c.skip(2)
+		c.cstring() // source_addr
+		c.skip(2)
+		c.cstring() // destination_addr
+		c.skip(3)
+		c.maskPayload()
+	}
+
+	return out
+}
+
+// rawDataCoding 从 submit_sm 和 deliver_sm 的原始字节读取 data_coding，gosmpp 不支持的编码解析后会丢失
+func rawDataCoding(raw []byte) byte {
+	if len(raw) < 16 {
+		return 0
+	}
+
+	c := &traceCursor{b: raw[16:]}
+	c.cstring() // service_type
+	c.skip(2)
+	c.cstring() // source_addr
+	c.skip(2)
+	c.cstring() // destination_addr
+	c.skip(3)
+	c.cstring() // schedule_delivery_time
+	c.cstring() // validity_period
+	c.skip(2)
+
+	return c.byte()
+}
+
+type traceCursor struct {
+	b   []byte
+	pos int
+}
+
+// skip 跳过 n 个字节，返回被跳过的字节
+func (c *traceCursor) skip(n int) []byte {
+	if n < 0 || c.pos+n > len(c.b) {
+		c.pos = len(c.b)
+		return nil
+	}
+	s := c.b[c.pos : c.pos+n]
+	c.pos += n
+	return s
+}
+
+func (c *traceCursor) byte() byte {
+	if s := c.skip(1); len(s) == 1 {
+		return s[0]
+	}
+	return 0
+}
+
+// cstring 读取以 0 结尾的字符串，返回不包含结尾 0 的字节
+func (c *traceCursor) cstring() []byte {
+	i := bytes.IndexByte(c.b[c.pos:], 0)
+	if i < 0 {
+		c.pos = len(c.b)
+		return nil
+	}
+	s := c.b[c.pos : c.pos+i]
+	c.pos += i + 1
+	return s
+}
+
+func (c *traceCursor) mask(s []byte) {
+	for i := range s {
+		s[i] = '*'
+	}
+}
+
+func (c *traceCursor) maskPayload() {
+	for len(c.b)-c.pos >= 4 {
+		tag := pdu.Tag(binary.BigEndian.Uint16(c.skip(2)))
+		value := c.skip(int(binary.BigEndian.Uint16(c.skip(2))))
+		if tag == pdu.TagMessagePayload {
+			c.mask(value)
+		}
+	}
+}
+
+// ======================== Sink ========================
+
+type traceWriter struct {
+	w  io.Writer
+	mu sync.Mutex
+}
+
+// NewTraceWriter create a TraceSink which writes the formatted traces to w
+func NewTraceWriter(w io.Writer) TraceSink {
+	return &traceWriter{w: w}
+}
+
+func (s *traceWriter) WriteTrace(t *Trace) {
+	s.mu.Lock()
+	_, _ = io.WriteString(s.w, t.String())
+	s.mu.Unlock()
+}
+
+// TraceFile a TraceSink which writes the formatted traces to a file, the file is rotated when its
+// size exceeds MaxSize, the rotated files are named path.1, path.2 ... and at most MaxBackups are kept
+type TraceFile struct {
+	path    string
+	maxSize int64
+	backups int
+	file    *os.File
+	size    int64
+	mu      sync.Mutex
+}
+
+// NewTraceFile create a TraceFile, maxSize <= 0 means never rotating
+func NewTraceFile(path string, maxSize int64, maxBackups int) (*TraceFile, error) {
+	f := &TraceFile{
+		path:    path,
+		maxSize: maxSize,
+		backups: maxBackups,
+	}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *TraceFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+func (f *TraceFile) rotate() error {
+	_ = f.file.Close()
+	f.file = nil
+
+	// path.n-1 -> path.n, ..., path -> path.1
+	if f.backups > 0 {
+		for i := f.backups - 1; i > 0; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", f.path, i), fmt.Sprintf("%s.%d", f.path, i+1))
+		}
+		_ = os.Rename(f.path, f.path+".1")
+	} else {
+		_ = os.Remove(f.path)
+	}
+
+	return f.open()
+}
+
+func (f *TraceFile) WriteTrace(t *Trace) {
+	s := t.String()
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return
+	}
+	if f.maxSize > 0 && f.size > 0 && f.size+int64(len(s)) > f.maxSize {
+		if err := f.rotate(); err != nil {
+			return
+		}
+	}
+	n, _ := f.file.WriteString(s)
+	f.size += int64(n)
+}
+
+// Close close the file, the traces written after Close are dropped
+func (f *TraceFile) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	return err
+}
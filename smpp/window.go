@@ -1,12 +1,13 @@
 package smpp
 
 import (
+	"container/heap"
+	"context"
 	"sync"
 	"time"
 
+	"github.com/linxGnu/gosmpp/data"
 	"golang.org/x/exp/maps"
-
-	"github.com/yyliziqiu/smpp/libs/xcq"
 )
 
 type Window interface {
@@ -17,27 +18,75 @@ type Window interface {
 	TakeTimeout() []*Request
 }
 
+// WindowAcquirer is implemented by the window which wakes the waiters when a slot is freed, the
+// session waits in Acquire when WindowBlock is non-zero, otherwise it polls Full
+type WindowAcquirer interface {
+	Acquire(context.Context) error
+}
+
+// WindowDeadline is implemented by the window which knows the earliest deadline of its requests,
+// the session clears the window at that deadline, otherwise it clears the window every WindowScan
+type WindowDeadline interface {
+	NextTimeout() time.Time
+}
+
+// WindowFeedback is implemented by the window which adjusts itself by the responses, the session
+// passes every response PDU and ErrResponseTimeout of requests taken from the window to Feedback
+type WindowFeedback interface {
+	Feedback(*Response)
+}
+
 func CreateWindow(sess *Session) Window {
 	switch sess.conf.WindowType {
 	case 1:
 		return NewLargeWindow(sess.conf.WindowSize, sess.conf.WindowWait)
+	case 2:
+		return NewAdaptiveWindow(sess.conf.WindowSize, sess.conf.WindowWait)
 	default:
 		return NewSmallWindow(sess.conf.WindowSize, sess.conf.WindowWait)
 	}
 }
 
 type SmallWindow struct {
-	size int                // 窗口大小
-	wait int64              // 请求超时时间
-	data map[int32]*Request //
-	mu   sync.Mutex         //
+	size  int                // 窗口大小
+	wait  time.Duration      // 请求超时时间
+	data  map[int32]*Request //
+	queue smallWindowHeap    // 按超时时间排序的最小堆，已取出的请求在堆顶或压缩时才删除
+	free  chan struct{}      // 窗口有空位时关闭，用于唤醒等待者
+	mu    sync.Mutex         //
+}
+
+type smallWindowItem struct {
+	request  *Request
+	sequence int32
+	expire   int64
+}
+
+type smallWindowHeap []smallWindowItem
+
+func (h smallWindowHeap) Len() int { return len(h) }
+
+func (h smallWindowHeap) Less(i, j int) bool { return h[i].expire < h[j].expire }
+
+func (h smallWindowHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *smallWindowHeap) Push(x any) { *h = append(*h, x.(smallWindowItem)) }
+
+func (h *smallWindowHeap) Pop() any {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = smallWindowItem{}
+	*h = old[:n-1]
+	return item
 }
 
 func NewSmallWindow(size int, wait time.Duration) Window {
 	return &SmallWindow{
-		size: size,
-		wait: int64(wait.Seconds()),
-		data: make(map[int32]*Request, size),
+		size:  size,
+		wait:  wait,
+		data:  make(map[int32]*Request, size),
+		queue: make(smallWindowHeap, 0, size),
 	}
 }
 
@@ -53,6 +102,35 @@ func (w *SmallWindow) full() bool {
 	return len(w.data) >= w.size
 }
 
+// Acquire wait until the window is not full or ctx is done
+func (w *SmallWindow) Acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if !w.full() {
+			w.mu.Unlock()
+			return nil
+		}
+		if w.free == nil {
+			w.free = make(chan struct{})
+		}
+		free := w.free
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-free:
+		}
+	}
+}
+
+func (w *SmallWindow) release() {
+	if w.free != nil {
+		close(w.free)
+		w.free = nil
+	}
+}
+
 func (w *SmallWindow) Data() map[int32]*Request {
 	w.mu.Lock()
 	requests := maps.Clone(w.data)
@@ -74,16 +152,53 @@ func (w *SmallWindow) put(request *Request) error {
 		return ErrWindowFull
 	}
 
-	w.data[request.Pdu.GetSequenceNumber()] = request
+	sequence := request.Pdu.GetSequenceNumber()
+	if _, ok := w.data[sequence]; ok {
+		return ErrSequenceInUse
+	}
+
+	w.data[sequence] = request
+	heap.Push(&w.queue, smallWindowItem{request: request, sequence: sequence, expire: request.ExpireAt(w.wait)})
+
+	// 堆中已取出的请求过多时压缩，使堆的大小不超过窗口大小的两倍
+	if len(w.queue) > 2*max(w.size, 1) {
+		w.compact()
+	}
 
 	return nil
 }
 
+// live 堆中的元素是否仍在窗口中，重试的请求会以新的序列号重新放入窗口
+func (w *SmallWindow) live(item smallWindowItem) bool {
+	request, ok := w.data[item.sequence]
+	return ok && request == item.request
+}
+
+func (w *SmallWindow) compact() {
+	queue := w.queue[:0]
+	for _, item := range w.queue {
+		if w.live(item) {
+			queue = append(queue, item)
+		}
+	}
+	clear(w.queue[len(queue):])
+	w.queue = queue
+	heap.Init(&w.queue)
+}
+
+// prune 删除堆顶已取出的请求
+func (w *SmallWindow) prune() {
+	for len(w.queue) > 0 && !w.live(w.queue[0]) {
+		heap.Pop(&w.queue)
+	}
+}
+
 func (w *SmallWindow) Take(sequence int32) *Request {
 	w.mu.Lock()
 	request, ok := w.data[sequence]
 	if ok {
 		delete(w.data, sequence)
+		w.release()
 	}
 	w.mu.Unlock()
 
@@ -94,36 +209,85 @@ func (w *SmallWindow) TakeTimeout() []*Request {
 	requests := make([]*Request, 0, 8)
 
 	w.mu.Lock()
-	curr := time.Now().Unix()
-	for seq, request := range w.data {
-		if curr-w.wait > request.SubmitAt {
-			delete(w.data, seq)
-			requests = append(requests, request)
-		}
+	curr := time.Now().UnixNano()
+	for w.prune(); len(w.queue) > 0 && w.queue[0].expire <= curr; w.prune() {
+		item := heap.Pop(&w.queue).(smallWindowItem)
+		delete(w.data, item.sequence)
+		requests = append(requests, item.request)
+	}
+	if len(requests) > 0 {
+		w.release()
 	}
 	w.mu.Unlock()
 
 	return requests
 }
 
+// NextTimeout get the earliest deadline of requests in window, zero time if window is empty
+func (w *SmallWindow) NextTimeout() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.prune()
+	if len(w.queue) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, w.queue[0].expire)
+}
+
 type LargeWindow struct {
-	size  int
-	wait  int64
+	size  int // 当前窗口大小，AdaptiveWindow 会调整此值
+	wait  time.Duration
 	data  map[int32]*LargeWindowValue
-	queue *xcq.Queue
+	queue largeWindowHeap // 按超时时间排序的最小堆
+	free  chan struct{}
 	mu    sync.Mutex
 }
 
 type LargeWindowValue struct {
 	Request *Request
+	expire  int64 // 超时时间
+	index   int   // 在堆中的位置
+}
+
+type largeWindowHeap []*LargeWindowValue
+
+func (h largeWindowHeap) Len() int { return len(h) }
+
+func (h largeWindowHeap) Less(i, j int) bool { return h[i].expire < h[j].expire }
+
+func (h largeWindowHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *largeWindowHeap) Push(x any) {
+	value := x.(*LargeWindowValue)
+	value.index = len(*h)
+	*h = append(*h, value)
+}
+
+func (h *largeWindowHeap) Pop() any {
+	old := *h
+	n := len(old)
+	value := old[n-1]
+	old[n-1] = nil
+	value.index = -1
+	*h = old[:n-1]
+	return value
 }
 
 func NewLargeWindow(size int, wait time.Duration) Window {
+	return newLargeWindow(size, wait)
+}
+
+func newLargeWindow(size int, wait time.Duration) *LargeWindow {
 	return &LargeWindow{
 		size:  size,
-		wait:  int64(wait.Seconds()),
+		wait:  wait,
 		data:  make(map[int32]*LargeWindowValue, size),
-		queue: xcq.New(size * 2),
+		queue: make(largeWindowHeap, 0, size),
 	}
 }
 
@@ -139,6 +303,35 @@ func (w *LargeWindow) full() bool {
 	return len(w.data) >= w.size
 }
 
+// Acquire wait until the window is not full or ctx is done
+func (w *LargeWindow) Acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if !w.full() {
+			w.mu.Unlock()
+			return nil
+		}
+		if w.free == nil {
+			w.free = make(chan struct{})
+		}
+		free := w.free
+		w.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-free:
+		}
+	}
+}
+
+func (w *LargeWindow) release() {
+	if w.free != nil {
+		close(w.free)
+		w.free = nil
+	}
+}
+
 func (w *LargeWindow) Data() map[int32]*Request {
 	requests := make(map[int32]*Request, len(w.data))
 
@@ -164,12 +357,18 @@ func (w *LargeWindow) put(request *Request) error {
 		return ErrWindowFull
 	}
 
+	sequence := request.Pdu.GetSequenceNumber()
+	if _, ok := w.data[sequence]; ok {
+		return ErrSequenceInUse
+	}
+
 	value := &LargeWindowValue{
 		Request: request,
+		expire:  request.ExpireAt(w.wait),
 	}
 
-	w.data[request.Pdu.GetSequenceNumber()] = value
-	w.queue.Push(value)
+	w.data[sequence] = value
+	heap.Push(&w.queue, value)
 
 	return nil
 }
@@ -189,31 +388,123 @@ func (w *LargeWindow) take(sequence int32) *Request {
 	}
 
 	delete(w.data, sequence)
+	heap.Remove(&w.queue, value.index)
+	w.release()
 
-	request := value.Request
-	value.Request = nil
-
-	return request
+	return value.Request
 }
 
 func (w *LargeWindow) TakeTimeout() []*Request {
-	curr := time.Now().Unix()
+	curr := time.Now().UnixNano()
 	list := make([]*Request, 0, 16)
 
 	w.mu.Lock()
-	w.queue.Pops(func(item any) bool {
-		value := item.(*LargeWindowValue)
-		if value.Request == nil {
-			return true
-		}
-		if curr-w.wait > value.Request.SubmitAt {
-			delete(w.data, value.Request.Pdu.GetSequenceNumber())
-			list = append(list, value.Request)
-			return true
-		}
-		return false
-	})
+	for len(w.queue) > 0 && w.queue[0].expire <= curr {
+		value := heap.Pop(&w.queue).(*LargeWindowValue)
+		delete(w.data, value.Request.Pdu.GetSequenceNumber())
+		list = append(list, value.Request)
+	}
+	if len(list) > 0 {
+		w.release()
+	}
 	w.mu.Unlock()
 
 	return list
 }
+
+// NextTimeout get the earliest deadline of requests in window, zero time if window is empty
+func (w *LargeWindow) NextTimeout() time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.queue) == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, w.queue[0].expire)
+}
+
+const (
+	adaptiveDecrease = 0.5 // 被限流或超时时窗口的缩减系数
+	adaptiveLatency  = 0.8 // 响应延迟升高时窗口的缩减系数
+	adaptiveRising   = 2.0 // 平滑延迟超过基准延迟的倍数时，认为延迟升高
+)
+
+// AdaptiveWindow is a LargeWindow whose size is adjusted by AIMD. The size grows by one for every
+// window of fast and successful responses, and shrinks multiplicatively on ESME_RTHROTTLED,
+// ESME_RMSGQFUL, response timeout or rising response latency, between 1 and the max size
+type AdaptiveWindow struct {
+	LargeWindow
+	max    int     // 最大窗口大小
+	cwnd   float64 // 拥塞窗口
+	base   float64 // 基准响应延迟
+	srtt   float64 // 平滑响应延迟
+	lastAt int64   // 最近一次缩减窗口的时间
+}
+
+func NewAdaptiveWindow(size int, wait time.Duration) Window {
+	init := max(1, size/4)
+	return &AdaptiveWindow{
+		LargeWindow: *newLargeWindow(init, wait),
+		max:         size,
+		cwnd:        float64(init),
+	}
+}
+
+// Size get the current size of the window
+func (w *AdaptiveWindow) Size() int {
+	w.mu.Lock()
+	size := w.size
+	w.mu.Unlock()
+
+	return size
+}
+
+func (w *AdaptiveWindow) Feedback(response *Response) {
+	now := time.Now().UnixNano()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// 超时、限流或队列已满时，成倍缩减窗口
+	if response.Error != nil {
+		if response.Error == ErrResponseTimeout {
+			w.decrease(now, adaptiveDecrease)
+		}
+		return
+	}
+	switch response.Pdu.GetHeader().CommandStatus {
+	case data.ESME_RTHROTTLED, data.ESME_RMSGQFUL:
+		w.decrease(now, adaptiveDecrease)
+		return
+	}
+
+	// 更新延迟，基准延迟缓慢上浮，以适应链路变化
+	rtt := float64(now - response.Request.SubmitAt)
+	if w.srtt == 0 {
+		w.base, w.srtt = rtt, rtt
+	} else {
+		w.base = min(rtt, w.base*1.001)
+		w.srtt += (rtt - w.srtt) / 8
+	}
+	if w.srtt > w.base*adaptiveRising {
+		w.decrease(now, adaptiveLatency)
+		return
+	}
+
+	// 响应快且成功时，每收到一个窗口的响应，窗口增加 1
+	w.cwnd = min(w.cwnd+1/w.cwnd, float64(w.max))
+	if size := int(w.cwnd); size > w.size {
+		w.size = size
+		w.release()
+	}
+}
+
+func (w *AdaptiveWindow) decrease(now int64, factor float64) {
+	// 同一批在途请求只缩减一次
+	if now-w.lastAt < int64(w.srtt) {
+		return
+	}
+	w.lastAt = now
+	w.cwnd = max(w.cwnd*factor, 1)
+	w.size = int(w.cwnd)
+}